@@ -1,16 +1,21 @@
 package auction_test
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os/exec"
+	"strings"
 
 	"github.com/cloudfoundry/gunk/natsrunner"
 	"github.com/onsi/auction/auctioneer"
 	"github.com/onsi/auction/http/rephttpclient"
+	"github.com/onsi/auction/instance"
+	"github.com/onsi/auction/labels"
 	"github.com/onsi/auction/lossyrep"
 	"github.com/onsi/auction/nats/repnatsclient"
 	"github.com/onsi/auction/representative"
+	"github.com/onsi/auction/scoring"
 	"github.com/onsi/auction/types"
 	"github.com/onsi/auction/util"
 	. "github.com/onsi/ginkgo"
@@ -30,13 +35,19 @@ const RemoteAuction = "remote"
 // knobs
 var communicationMode string
 var auctioneerMode string
+var algorithm string
+var scoringStrategy string
+var scoringConfig string
+var overcommit string
 
 var rules types.AuctionRules
 var timeout time.Duration
+var reservationTTL time.Duration
 
 var numAuctioneers = 100
 var numReps = 100
-var repResources = 100
+var repResources = instance.Resources{MemoryMB: 100 * 256, DiskMB: 100 * 1024, Containers: 100}
+var repPorts = representative.PortRange{Min: 61000, Max: 61199}
 
 // plumbing
 var sessionsToTerminate []*gexec.Session
@@ -49,11 +60,18 @@ var communicator types.AuctionCommunicator
 func init() {
 	flag.StringVar(&communicationMode, "communicationMode", "inprocess", "one of inprocess, http, nats")
 	flag.StringVar(&auctioneerMode, "auctioneerMode", "inprocess", "one of inprocess, remote")
+	flag.StringVar(&algorithm, "algorithm", auctioneer.DefaultAlgorithm, "the placement algorithm, one of "+strings.Join(auctioneer.AlgorithmNames(), ", "))
+	flag.StringVar(&scoringStrategy, "scoring", "default", "the scoring strategy reps vote with")
+	flag.StringVar(&scoringConfig, "scoringConfig", "", "path to a JSON file of weighted scoring terms (overrides -scoring)")
+	flag.StringVar(&overcommit, "overcommit", "", "per-resource overcommit factors for every rep, e.g. memory=1.5")
 
 	flag.IntVar(&(auctioneer.DefaultRules.MaxRounds), "maxRounds", auctioneer.DefaultRules.MaxRounds, "the maximum number of rounds per auction")
 	flag.IntVar(&(auctioneer.DefaultRules.MaxBiddingPool), "maxBiddingPool", auctioneer.DefaultRules.MaxBiddingPool, "the maximum number of participants in the pool")
 	flag.IntVar(&(auctioneer.DefaultRules.MaxConcurrent), "maxConcurrent", auctioneer.DefaultRules.MaxConcurrent, "the maximum number of concurrent auctions to run")
 	flag.BoolVar(&(auctioneer.DefaultRules.RepickEveryRound), "repickEveryRound", auctioneer.DefaultRules.RepickEveryRound, "whether to repick every round")
+	flag.Float64Var(&(auctioneer.DefaultRules.ZoneBalanceWeight), "zoneBalanceWeight", auctioneer.DefaultRules.ZoneBalanceWeight, "how strongly to avoid zones that already run more of an app")
+	flag.BoolVar(&(auctioneer.DefaultRules.AllowPreemption), "allowPreemption", auctioneer.DefaultRules.AllowPreemption, "whether high priority instances may preempt lower priority ones on a full cluster")
+	flag.IntVar(&(auctioneer.DefaultRules.SecondRoundTopK), "secondRoundTopK", auctioneer.DefaultRules.SecondRoundTopK, "how many of the best first round bidders vote in the second round (0 for all)")
 }
 
 func TestAuction(t *testing.T) {
@@ -64,6 +82,15 @@ func TestAuction(t *testing.T) {
 var _ = BeforeSuite(func() {
 	fmt.Printf("Running in %s communicationMode\n", communicationMode)
 	fmt.Printf("Running in %s auctioneerMode\n", auctioneerMode)
+	if scoringConfig != "" {
+		fmt.Printf("Scoring with terms from %s\n", scoringConfig)
+	} else {
+		fmt.Printf("Scoring with %s strategy\n", scoringStrategy)
+	}
+
+	_, err := auctioneer.LookupAlgorithm(algorithm)
+	Ω(err).ShouldNot(HaveOccurred())
+	fmt.Printf("Placing with the %s algorithm\n", algorithm)
 
 	if auctioneerMode == RemoteAuction && communicationMode != NATS {
 		panic("to use remote auctioneers, you must communicate via nats")
@@ -71,6 +98,7 @@ var _ = BeforeSuite(func() {
 
 	//parse flags to set up rules
 	timeout = 500 * time.Millisecond
+	reservationTTL = 10 * time.Second
 	natsPort = 5222 + GinkgoParallelNode()
 
 	natsRunner = natsrunner.NewNATSRunner(natsPort)
@@ -83,13 +111,19 @@ var _ = BeforeSuite(func() {
 	client, guids = buildClient(numReps, repResources)
 
 	if auctioneerMode == InProcess {
-		communicator = func(auctionRequest types.AuctionRequest) types.AuctionResult {
-			return auctioneer.Auction(client, auctionRequest)
+		communicator = func(ctx context.Context, auctionRequest types.AuctionRequest, observer types.AuctionObserver) types.AuctionResult {
+			if auctionRequest.Algorithm == "" {
+				auctionRequest.Algorithm = algorithm
+			}
+			return auctioneer.Auction(ctx, client, auctionRequest, observer)
 		}
 	} else if auctioneerMode == RemoteAuction {
 		startAuctioneers(numAuctioneers)
-		communicator = func(auctionRequest types.AuctionRequest) types.AuctionResult {
-			return auctioneer.RemoteAuction(natsRunner.MessageBus, auctionRequest)
+		communicator = func(ctx context.Context, auctionRequest types.AuctionRequest, observer types.AuctionObserver) types.AuctionResult {
+			if auctionRequest.Algorithm == "" {
+				auctionRequest.Algorithm = algorithm
+			}
+			return auctioneer.RemoteAuction(ctx, natsRunner.MessageBus, auctionRequest, observer)
 		}
 	} else {
 		panic("wat?")
@@ -98,7 +132,8 @@ var _ = BeforeSuite(func() {
 
 var _ = BeforeEach(func() {
 	for _, guid := range guids {
-		client.Reset(guid)
+		err := client.Reset(guid)
+		Ω(err).ShouldNot(HaveOccurred())
 	}
 
 	util.ResetGuids()
@@ -130,7 +165,50 @@ func startAuctioneers(numAuctioneers int) {
 	}
 }
 
-func buildClient(numReps int, repResources int) (types.TestRepPoolClient, []string) {
+// every rep supports lucid64, only every other rep supports trusty64
+func repStacks(index int) []string {
+	if index%2 == 0 {
+		return []string{"lucid64", "trusty64"}
+	}
+	return []string{"lucid64"}
+}
+
+// reps are spread round-robin across three zones
+func repZone(index int) string {
+	return fmt.Sprintf("z%d", index%3+1)
+}
+
+// one in ten reps has a gpu, one in four is a staging rep
+func repLabels(index int) string {
+	gpu, tier := "false", "prod"
+	if index%10 == 0 {
+		gpu = "true"
+	}
+	if index%4 == 0 {
+		tier = "staging"
+	}
+	return fmt.Sprintf("gpu=%s,tier=%s", gpu, tier)
+}
+
+func labelsFor(index int) map[string]string {
+	repLabels, err := labels.ParseLabels(repLabels(index))
+	Ω(err).ShouldNot(HaveOccurred())
+	return repLabels
+}
+
+func buildScorer() scoring.Scorer {
+	if scoringConfig != "" {
+		scorer, err := scoring.LoadComposite(scoringConfig)
+		Ω(err).ShouldNot(HaveOccurred())
+		return scorer
+	}
+
+	scorer, err := scoring.Lookup(scoringStrategy)
+	Ω(err).ShouldNot(HaveOccurred())
+	return scorer
+}
+
+func buildClient(numReps int, repResources instance.Resources) (types.TestRepPoolClient, []string) {
 	repNodeBinary, err := gexec.Build("github.com/onsi/auction/repnode")
 	Ω(err).ShouldNot(HaveOccurred())
 
@@ -140,13 +218,28 @@ func buildClient(numReps int, repResources int) (types.TestRepPoolClient, []stri
 		lossyrep.Timeout = 50 * time.Millisecond
 		lossyrep.Flakiness = 0.95
 
+		scorer := buildScorer()
+		overcommitFactors, err := representative.ParseOvercommit(overcommit)
+		Ω(err).ShouldNot(HaveOccurred())
+
 		guids := []string{}
 		repMap := map[string]*representative.Representative{}
 
 		for i := 0; i < numReps; i++ {
 			guid := util.NewGuid("REP")
 			guids = append(guids, guid)
-			repMap[guid] = representative.New(guid, repResources)
+			rep, err := representative.New(guid, representative.Config{
+				TotalResources: repResources,
+				Overcommit:     overcommitFactors,
+				Ports:          repPorts,
+				Stacks:         repStacks(i),
+				Zone:           repZone(i),
+				Labels:         labelsFor(i),
+				ReservationTTL: reservationTTL,
+				Scorer:         scorer,
+			})
+			Ω(err).ShouldNot(HaveOccurred())
+			repMap[guid] = rep
 		}
 
 		client := lossyrep.New(repMap, map[string]bool{})
@@ -161,7 +254,15 @@ func buildClient(numReps int, repResources int) (types.TestRepPoolClient, []stri
 				repNodeBinary,
 				"-guid", guid,
 				"-natsAddr", fmt.Sprintf("127.0.0.1:%d", natsPort),
-				"-resources", fmt.Sprintf("%d", repResources),
+				"-resources", repResources.String(),
+				"-stacks", strings.Join(repStacks(i), ","),
+				"-zone", repZone(i),
+				"-labels", repLabels(i),
+				"-reservationTTL", reservationTTL.String(),
+				"-scoring", scoringStrategy,
+				"-scoringConfig", scoringConfig,
+				"-overcommit", overcommit,
+				"-ports", repPorts.String(),
 			)
 
 			sess, err := gexec.Start(serverCmd, GinkgoWriter, GinkgoWriter)
@@ -189,7 +290,15 @@ func buildClient(numReps int, repResources int) (types.TestRepPoolClient, []stri
 				repNodeBinary,
 				"-guid", guid,
 				"-httpAddr", fmt.Sprintf("0.0.0.0:%d", port),
-				"-resources", fmt.Sprintf("%d", repResources),
+				"-resources", repResources.String(),
+				"-stacks", strings.Join(repStacks(i), ","),
+				"-zone", repZone(i),
+				"-labels", repLabels(i),
+				"-reservationTTL", reservationTTL.String(),
+				"-scoring", scoringStrategy,
+				"-scoringConfig", scoringConfig,
+				"-overcommit", overcommit,
+				"-ports", repPorts.String(),
 			)
 
 			repMap[guid] = fmt.Sprintf("http://127.0.0.1:%d", port)
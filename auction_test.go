@@ -1,8 +1,13 @@
 package auction_test
 
 import (
+	"context"
+	"fmt"
+	"sync"
+
 	"github.com/onsi/auction/auctioneer"
 	"github.com/onsi/auction/instance"
+	"github.com/onsi/auction/types"
 	"github.com/onsi/auction/util"
 	"github.com/onsi/auction/visualization"
 	. "github.com/onsi/ginkgo"
@@ -15,10 +20,12 @@ var _ = Describe("Auction", func() {
 	var initialDistributions map[int][]instance.Instance
 	var numApps int
 
+	instanceResources := instance.Resources{MemoryMB: 256, DiskMB: 1024, Containers: 1}
+
 	generateUniqueInstances := func(numInstances int) []instance.Instance {
 		instances := []instance.Instance{}
 		for i := 0; i < numInstances; i++ {
-			instances = append(instances, instance.New(util.NewGuid("APP"), 1))
+			instances = append(instances, instance.New(util.NewGuid("APP"), instanceResources))
 		}
 		return instances
 	}
@@ -30,7 +37,7 @@ var _ = Describe("Auction", func() {
 	generateInstancesWithRandomColors := func(numInstances int) []instance.Instance {
 		instances := []instance.Instance{}
 		for i := 0; i < numInstances; i++ {
-			instances = append(instances, instance.New(randomColor(), 1))
+			instances = append(instances, instance.New(randomColor(), instanceResources))
 		}
 		return instances
 	}
@@ -38,7 +45,7 @@ var _ = Describe("Auction", func() {
 	generateInstancesForAppGuid := func(numInstances int, appGuid string) []instance.Instance {
 		instances := []instance.Instance{}
 		for i := 0; i < numInstances; i++ {
-			instances = append(instances, instance.New(appGuid, 1))
+			instances = append(instances, instance.New(appGuid, instanceResources))
 		}
 		return instances
 	}
@@ -58,7 +65,8 @@ var _ = Describe("Auction", func() {
 
 	JustBeforeEach(func() {
 		for index, instances := range initialDistributions {
-			client.SetInstances(guids[index], instances)
+			err := client.SetInstances(guids[index], instances)
+			Ω(err).ShouldNot(HaveOccurred())
 		}
 	})
 
@@ -70,7 +78,7 @@ var _ = Describe("Auction", func() {
 		It("should distribute evenly", func() {
 			instances := generateUniqueInstances(numApps)
 
-			results, duration := auctioneer.HoldAuctionsFor(client, instances, guids, rules, communicator)
+			results, duration := auctioneer.HoldAuctionsFor(context.Background(), client, instances, guids, rules, communicator)
 
 			visualization.PrintReport(client, results, guids, duration, rules)
 		})
@@ -89,7 +97,7 @@ var _ = Describe("Auction", func() {
 		It("should distribute evenly", func() {
 			instances := generateUniqueInstances(numApps)
 
-			results, duration := auctioneer.HoldAuctionsFor(client, instances, guids, rules, communicator)
+			results, duration := auctioneer.HoldAuctionsFor(context.Background(), client, instances, guids, rules, communicator)
 
 			visualization.PrintReport(client, results, guids, duration, rules)
 		})
@@ -113,7 +121,7 @@ var _ = Describe("Auction", func() {
 		It("should distribute evenly when watters does a demo", func() {
 			instances := generateInstancesForAppGuid(numDemoInstances, "red")
 
-			results, duration := auctioneer.HoldAuctionsFor(client, instances, guids[:numReps], rules, communicator)
+			results, duration := auctioneer.HoldAuctionsFor(context.Background(), client, instances, guids[:numReps], rules, communicator)
 
 			visualization.PrintReport(client, results, guids[:numReps], duration, rules)
 		})
@@ -134,12 +142,415 @@ var _ = Describe("Auction", func() {
 		It("should distribute evenly", func() {
 			instances := generateUniqueInstances(numDemoInstances)
 
-			results, duration := auctioneer.HoldAuctionsFor(client, instances, guids[:numReps], rules, communicator)
+			results, duration := auctioneer.HoldAuctionsFor(context.Background(), client, instances, guids[:numReps], rules, communicator)
 
 			visualization.PrintReport(client, results, guids[:numReps], duration, rules)
 		})
 	})
 
+	Context("with instances that require a stack only some representatives support", func() {
+		BeforeEach(func() {
+			numApps = 400
+		})
+
+		It("should only place instances on compatible representatives", func() {
+			instances := generateUniqueInstances(numApps)
+			for i := range instances {
+				instances[i].Stack = "trusty64"
+			}
+
+			results, duration := auctioneer.HoldAuctionsFor(context.Background(), client, instances, guids, rules, communicator)
+
+			visualization.PrintReport(client, results, guids, duration, rules)
+
+			compatible := map[string]bool{}
+			for i, guid := range guids {
+				for _, stack := range repStacks(i) {
+					if stack == "trusty64" {
+						compatible[guid] = true
+					}
+				}
+			}
+
+			for _, result := range results {
+				if result.Winner != "" {
+					Ω(compatible[result.Winner]).Should(BeTrue(), "%s won an instance it cannot run", result.Winner)
+				}
+			}
+		})
+	})
+
+	Context("with instances constrained to labelled representatives", func() {
+		BeforeEach(func() {
+			numApps = 50
+		})
+
+		It("should only place instances on matching representatives", func() {
+			instances := generateUniqueInstances(numApps)
+			for i := range instances {
+				instances[i].Constraint = "gpu=true,tier notin (staging)"
+			}
+
+			results, duration := auctioneer.HoldAuctionsFor(context.Background(), client, instances, guids, rules, communicator)
+
+			visualization.PrintReport(client, results, guids, duration, rules)
+
+			matching := map[string]bool{}
+			for i, guid := range guids {
+				matching[guid] = labelsFor(i)["gpu"] == "true" && labelsFor(i)["tier"] != "staging"
+			}
+
+			for _, result := range results {
+				if result.Winner != "" {
+					Ω(matching[result.Winner]).Should(BeTrue(), "%s won an instance it does not match", result.Winner)
+				}
+			}
+		})
+	})
+
+	Context("with instances no representative can satisfy", func() {
+		BeforeEach(func() {
+			numApps = 20
+		})
+
+		It("should give up without exhausting every round", func() {
+			instances := generateUniqueInstances(numApps)
+			for i := range instances {
+				instances[i].Constraint = "tier=dev"
+			}
+
+			results, _ := auctioneer.HoldAuctionsFor(context.Background(), client, instances, guids, rules, communicator)
+
+			for _, result := range results {
+				Ω(result.Winner).Should(BeEmpty())
+				Ω(result.Outcome).Should(Equal(types.OutcomeNoMatchingRepresentatives))
+				Ω(result.Error).Should(Equal(auctioneer.NoMatchingRepresentatives.Error()))
+				Ω(result.NumRounds).Should(BeNumerically("<", rules.MaxRounds))
+			}
+		})
+	})
+
+	Context("with instances whose constraint does not parse", func() {
+		BeforeEach(func() {
+			numApps = 20
+		})
+
+		It("should reject them without asking any representative", func() {
+			instances := generateUniqueInstances(numApps)
+			for i := range instances {
+				instances[i].Constraint = "tier in (a=b)"
+			}
+
+			results, _ := auctioneer.HoldAuctionsFor(context.Background(), client, instances, guids, rules, communicator)
+
+			for _, result := range results {
+				Ω(result.Winner).Should(BeEmpty())
+				Ω(result.Outcome).Should(Equal(types.OutcomeInvalidRequest))
+				Ω(result.Error).ShouldNot(BeEmpty())
+				Ω(result.NumRounds).Should(BeZero())
+				Ω(result.NumVotes).Should(BeZero())
+			}
+		})
+	})
+
+	Context("when no representative can be reached", func() {
+		It("should report a transport error", func() {
+			result := auctioneer.Auction(context.Background(), unreachableClient{client}, types.AuctionRequest{
+				Instance:  generateUniqueInstances(1)[0],
+				RepGuids:  guids,
+				Rules:     rules,
+				Algorithm: algorithm,
+			})
+
+			Ω(result.Winner).Should(BeEmpty())
+			Ω(result.Outcome).Should(Equal(types.OutcomeTransportError))
+			Ω(result.Error).Should(Equal(auctioneer.BiddersUnreachable.Error()))
+			Ω(result.NumRounds).Should(Equal(rules.MaxRounds))
+		})
+	})
+
+	Context("when evacuating a representative", func() {
+		BeforeEach(func() {
+			initialDistributions[0] = generateInstancesWithRandomColors(40)
+		})
+
+		It("should move everything it hosts onto the remaining representatives", func() {
+			results, err := auctioneer.Evacuate(context.Background(), client, guids[0], guids, rules)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(results).Should(HaveLen(40))
+
+			for _, result := range results {
+				Ω(result.Error).Should(BeEmpty())
+				Ω(result.Winner).ShouldNot(Equal(guids[0]))
+			}
+
+			remaining, err := client.Instances(guids[0])
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(remaining).Should(BeEmpty())
+		})
+
+		It("should never leave an instance on both representatives when cancelled part way through", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			evacuationRules := rules
+			evacuationRules.MaxConcurrent = 4
+			cancelling := &cancellingClient{TestRepPoolClient: client, cancelAfter: 10, cancel: cancel}
+			results, err := auctioneer.Evacuate(ctx, cancelling, guids[0], guids, evacuationRules)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(results).Should(HaveLen(40))
+
+			remaining, err := client.Instances(guids[0])
+			Ω(err).ShouldNot(HaveOccurred())
+			stillOnDrainingRep := map[string]bool{}
+			for _, inst := range remaining {
+				stillOnDrainingRep[inst.InstanceGuid] = true
+			}
+
+			numPlaced := 0
+			for _, result := range results {
+				if result.Winner != "" {
+					numPlaced++
+					Ω(result.Error).Should(BeEmpty())
+					Ω(stillOnDrainingRep[result.Instance.InstanceGuid]).Should(BeFalse(), "%s runs on both %s and %s", result.Instance.InstanceGuid, guids[0], result.Winner)
+				} else {
+					Ω(stillOnDrainingRep[result.Instance.InstanceGuid]).Should(BeTrue())
+				}
+			}
+
+			Ω(numPlaced).Should(BeNumerically("<", 40))
+			Ω(remaining).Should(HaveLen(40 - numPlaced))
+		})
+	})
+
+	Context("when limiting the second round to the top bidders", func() {
+		totalVotes := func(results []types.AuctionResult) int {
+			total := 0
+			for _, result := range results {
+				total += result.NumVotes
+			}
+			return total
+		}
+
+		It("should cast fewer votes than polling every bidder", func() {
+			allBiddersRules := rules
+			allBiddersRules.SecondRoundTopK = 0
+			instances := generateInstancesWithRandomColors(500)
+			results, duration := auctioneer.HoldAuctionsFor(context.Background(), client, instances, guids, allBiddersRules, communicator)
+			visualization.PrintReport(client, results, guids, duration, allBiddersRules)
+			allBiddersVotes := totalVotes(results)
+
+			for _, guid := range guids {
+				err := client.Reset(guid)
+				Ω(err).ShouldNot(HaveOccurred())
+			}
+
+			topKRules := rules
+			topKRules.SecondRoundTopK = 3
+			instances = generateInstancesWithRandomColors(500)
+			results, duration = auctioneer.HoldAuctionsFor(context.Background(), client, instances, guids, topKRules, communicator)
+			visualization.PrintReport(client, results, guids, duration, topKRules)
+
+			Ω(totalVotes(results)).Should(BeNumerically("<", allBiddersVotes))
+		})
+	})
+
+	Context("when comparing placement algorithms", func() {
+		It("should place everything with each of them", func() {
+			for _, name := range auctioneer.AlgorithmNames() {
+				for _, guid := range guids {
+					err := client.Reset(guid)
+					Ω(err).ShouldNot(HaveOccurred())
+				}
+
+				algorithmCommunicator := func(name string) types.AuctionCommunicator {
+					return func(ctx context.Context, auctionRequest types.AuctionRequest, observer types.AuctionObserver) types.AuctionResult {
+						auctionRequest.Algorithm = name
+						return communicator(ctx, auctionRequest, observer)
+					}
+				}(name)
+
+				fmt.Printf("\n%s\n", name)
+				instances := generateInstancesWithRandomColors(500)
+				results, duration := auctioneer.HoldAuctionsFor(context.Background(), client, instances, guids, rules, algorithmCommunicator)
+				visualization.PrintReport(client, results, guids, duration, rules)
+
+				for _, result := range results {
+					Ω(result.Winner).ShouldNot(BeEmpty())
+				}
+			}
+		})
+	})
+
+	Context("when the auctions are cancelled", func() {
+		It("should give up without leaving any reservations behind", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			instances := generateUniqueInstances(50)
+			results, _ := auctioneer.HoldAuctionsFor(ctx, client, instances, guids, rules, communicator)
+
+			for _, result := range results {
+				Ω(result.Winner).Should(BeEmpty())
+				Ω(result.Outcome).Should(Equal(types.OutcomeCancelled))
+				Ω(result.Error).Should(Equal(context.Canceled.Error()))
+			}
+
+			for _, guid := range guids {
+				state, err := client.State(guid)
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(state.ReservedResources).Should(BeZero())
+			}
+		})
+	})
+
+	Context("when observing auctions", func() {
+		It("should report every round, claim and completion", func() {
+			observer := &countingObserver{}
+			instances := generateUniqueInstances(200)
+			results, _ := auctioneer.HoldAuctionsFor(context.Background(), client, instances, guids, rules, communicator, observer)
+
+			numRounds, numPlaced := 0, 0
+			for _, result := range results {
+				numRounds += result.NumRounds
+				if result.Winner != "" {
+					numPlaced++
+				}
+			}
+
+			Ω(observer.completed).Should(Equal(len(instances)))
+			if auctioneerMode == InProcess {
+				Ω(observer.rounds).Should(Equal(numRounds))
+				Ω(observer.claimed).Should(Equal(numPlaced))
+			}
+		})
+	})
+
+	Context("when an app scales down and back up", func() {
+		BeforeEach(func() {
+			for i := 0; i < numReps; i++ {
+				initialDistributions[i] = generateInstancesForAppGuid(util.R.Intn(20)+10, "red")
+			}
+		})
+
+		It("should free the stopped instances' resources for new ones", func() {
+			numStopped := 0
+			for i := 0; i < numReps; i++ {
+				for _, inst := range initialDistributions[i][:10] {
+					err := client.Stop(context.Background(), guids[i], inst.InstanceGuid)
+					Ω(err).ShouldNot(HaveOccurred())
+					numStopped++
+				}
+
+				remaining, err := client.Instances(guids[i])
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(remaining).Should(HaveLen(len(initialDistributions[i]) - 10))
+			}
+
+			instances := generateInstancesForAppGuid(numStopped, "red")
+			results, duration := auctioneer.HoldAuctionsFor(context.Background(), client, instances, guids, rules, communicator)
+			visualization.PrintReport(client, results, guids, duration, rules)
+		})
+	})
+
+	Context("when a representative shrinks below what it runs", func() {
+		BeforeEach(func() {
+			initialDistributions[0] = generateUniqueInstances(40)
+		})
+
+		AfterEach(func() {
+			_, err := client.SetTotalResources(guids[0], types.ResizeRequest{TotalResources: repResources})
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("should report the overage, nominate evictions and stop taking instances", func() {
+			shrunk := repResources
+			shrunk.Containers = 30
+
+			result, err := client.SetTotalResources(guids[0], types.ResizeRequest{TotalResources: shrunk, NominateEvictions: true})
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(result.OverCommitted).Should(BeTrue())
+			Ω(result.Overage).Should(Equal(instance.Resources{Containers: 10}))
+			Ω(result.EvictionCandidates).Should(HaveLen(10))
+
+			votes, err := client.Vote(context.Background(), guids[:1], generateUniqueInstances(1)[0])
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(votes[0].Error).Should(Equal(types.InsufficientResources.Error()))
+		})
+	})
+
+	Context("with high priority instances and a full cluster", func() {
+		var numFullReps int
+
+		BeforeEach(func() {
+			numFullReps = 5
+			for i := 0; i < numFullReps; i++ {
+				initialDistributions[i] = generateUniqueInstances(repResources.Containers)
+			}
+		})
+
+		It("should preempt lower priority instances when allowed to", func() {
+			instances := generateInstancesForAppGuid(10, "red")
+			for i := range instances {
+				instances[i].Priority = 1
+			}
+
+			preemptionRules := rules
+			preemptionRules.AllowPreemption = true
+
+			results, duration := auctioneer.HoldAuctionsFor(context.Background(), client, instances, guids[:numFullReps], preemptionRules, communicator)
+			visualization.PrintReport(client, results, guids[:numFullReps], duration, preemptionRules)
+
+			numPreempted := 0
+			for _, result := range results {
+				Ω(result.Winner).ShouldNot(BeEmpty())
+				numPreempted += len(result.Preempted)
+			}
+			Ω(numPreempted).Should(Equal(len(instances)))
+		})
+	})
+
+	Context("with instances that need host ports", func() {
+		var numPortReps int
+
+		BeforeEach(func() {
+			numPortReps = 5
+		})
+
+		It("should never hand out the same port twice and fail once the ports run out", func() {
+			instances := generateUniqueInstances(60)
+			for i := range instances {
+				instances[i].Ports = 20
+			}
+
+			results, duration := auctioneer.HoldAuctionsFor(context.Background(), client, instances, guids[:numPortReps], rules, communicator)
+			visualization.PrintReport(client, results, guids[:numPortReps], duration, rules)
+
+			portsByRep := map[string]map[int]bool{}
+			numPlaced := 0
+			for _, result := range results {
+				if result.Winner == "" {
+					Ω(result.Outcome).Should(Equal(types.OutcomeAllBiddersFull))
+					continue
+				}
+
+				numPlaced++
+				Ω(result.Outcome).Should(Equal(types.OutcomePlaced))
+				Ω(result.Instance.HostPorts).Should(HaveLen(20))
+
+				if portsByRep[result.Winner] == nil {
+					portsByRep[result.Winner] = map[int]bool{}
+				}
+				for _, port := range result.Instance.HostPorts {
+					Ω(portsByRep[result.Winner][port]).Should(BeFalse(), "port %d handed out twice on %s", port, result.Winner)
+					portsByRep[result.Winner][port] = true
+				}
+			}
+
+			Ω(numPlaced).Should(Equal(numPortReps * repPorts.Size() / 20))
+		})
+	})
+
 	Context("apps with multiple instances", func() {
 		var newInstances map[string]int
 
@@ -160,7 +571,7 @@ var _ = Describe("Auction", func() {
 
 			It("should distribute evenly", func() {
 				instances := generateNewColorInstances(newInstances)
-				results, duration := auctioneer.HoldAuctionsFor(client, instances, guids, rules, communicator)
+				results, duration := auctioneer.HoldAuctionsFor(context.Background(), client, instances, guids, rules, communicator)
 				visualization.PrintReport(client, results, guids, duration, rules)
 			})
 		})
@@ -180,9 +591,132 @@ var _ = Describe("Auction", func() {
 				instances := generateNewColorInstances(newInstances)
 				instances = append(instances, generateUniqueInstances(2000)...)
 
-				results, duration := auctioneer.HoldAuctionsFor(client, instances, guids, rules, communicator)
+				results, duration := auctioneer.HoldAuctionsFor(context.Background(), client, instances, guids, rules, communicator)
+				visualization.PrintReport(client, results, guids, duration, rules)
+			})
+		})
+
+		Context("when auctioning each app as a whole", func() {
+			BeforeEach(func() {
+				newInstances = map[string]int{
+					"red":     1000,
+					"plurple": 750,
+					"cyan":    500,
+					"yellow":  250,
+					"gray":    100,
+				}
+			})
+
+			It("should place every instance and distribute evenly", func() {
+				requests := []types.AppAuctionRequest{}
+				numInstances := 0
+				for color, num := range newInstances {
+					requests = append(requests, types.AppAuctionRequest{
+						Instance:     instance.New(color, instanceResources),
+						NumInstances: num,
+						RepGuids:     guids,
+						Rules:        rules,
+					})
+					numInstances += num
+				}
+
+				observer := &countingObserver{}
+				appResults, duration := auctioneer.HoldAppAuctionsFor(context.Background(), client, requests, rules.MaxConcurrent, observer)
+
+				//rounds and votes are spent on the app as a whole, so only its first
+				//placement carries them and the report's totals aren't multiplied
+				results := []types.AuctionResult{}
+				for _, appResult := range appResults {
+					Ω(appResult.Unplaced).Should(BeEmpty())
+					Ω(appResult.Outcome).Should(Equal(types.OutcomePlaced))
+					for i, placement := range appResult.Placements {
+						result := types.AuctionResult{
+							Instance: placement.Instance,
+							Winner:   placement.Rep,
+							Duration: appResult.Duration,
+						}
+						if i == 0 {
+							result.NumRounds = appResult.NumRounds
+							result.NumVotes = appResult.NumVotes
+						}
+						results = append(results, result)
+					}
+				}
+				Ω(results).Should(HaveLen(numInstances))
+				Ω(observer.claimed).Should(Equal(numInstances))
+				Ω(observer.completed).Should(Equal(numInstances))
+
 				visualization.PrintReport(client, results, guids, duration, rules)
 			})
 		})
 	})
 })
+
+// cancellingClient cancels an auction's context once enough instances have been claimed
+type cancellingClient struct {
+	types.TestRepPoolClient
+	lock        sync.Mutex
+	claimed     int
+	cancelAfter int
+	cancel      context.CancelFunc
+}
+
+func (c *cancellingClient) Claim(ctx context.Context, guid string, inst instance.Instance) ([]int, error) {
+	hostPorts, err := c.TestRepPoolClient.Claim(ctx, guid, inst)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.claimed++
+	if c.claimed == c.cancelAfter {
+		c.cancel()
+	}
+
+	return hostPorts, err
+}
+
+// unreachableClient loses every vote and reserve
+type unreachableClient struct {
+	types.TestRepPoolClient
+}
+
+func (c unreachableClient) Vote(ctx context.Context, guids []string, inst instance.Instance) ([]types.VoteResult, error) {
+	votes := []types.VoteResult{}
+	for _, guid := range guids {
+		votes = append(votes, types.VoteResult{Rep: guid, Error: types.TimeoutError.Error()})
+	}
+	return votes, nil
+}
+
+func (c unreachableClient) ReserveAndRecastVote(ctx context.Context, guid string, inst instance.Instance) (float64, error) {
+	return 0, types.TimeoutError
+}
+
+// countingObserver tallies auction events
+type countingObserver struct {
+	auctioneer.NoopObserver
+	lock      sync.Mutex
+	rounds    int
+	claimed   int
+	completed int
+}
+
+func (o *countingObserver) RoundStarted(request types.AuctionRequest, round int, bidders []string) {
+	o.lock.Lock()
+	o.rounds++
+	o.lock.Unlock()
+}
+
+func (o *countingObserver) ClaimCompleted(request types.AuctionRequest, round int, rep string, hostPorts []int, err error) {
+	if err != nil {
+		return
+	}
+	o.lock.Lock()
+	o.claimed++
+	o.lock.Unlock()
+}
+
+func (o *countingObserver) AuctionCompleted(request types.AuctionRequest, result types.AuctionResult) {
+	o.lock.Lock()
+	o.completed++
+	o.lock.Unlock()
+}
@@ -0,0 +1,257 @@
+package auctioneer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/onsi/auction/types"
+)
+
+// Algorithm places a single instance on one of the request's reps, honoring its rules
+type Algorithm interface {
+	Place(ctx context.Context, client types.RepPoolClient, auctionRequest types.AuctionRequest, observer types.AuctionObserver) types.AuctionResult
+}
+
+type AlgorithmFunc func(ctx context.Context, client types.RepPoolClient, auctionRequest types.AuctionRequest, observer types.AuctionObserver) types.AuctionResult
+
+func (f AlgorithmFunc) Place(ctx context.Context, client types.RepPoolClient, auctionRequest types.AuctionRequest, observer types.AuctionObserver) types.AuctionResult {
+	return f(ctx, client, auctionRequest, observer)
+}
+
+const DefaultAlgorithm = "reserve-and-recast"
+
+// ReserveAndRecast is the original two round auction, the only one that can preempt
+var ReserveAndRecast = AlgorithmFunc(reserveAndRecast)
+
+// PowerOfTwoChoices polls two random reps each round and claims on the better one
+var PowerOfTwoChoices = AlgorithmFunc(func(ctx context.Context, client types.RepPoolClient, auctionRequest types.AuctionRequest, observer types.AuctionObserver) types.AuctionResult {
+	return voteAndClaim(ctx, client, auctionRequest, observer, 2)
+})
+
+// Greedy polls the bidding pool once a round and claims on the best bidder without a recast
+var Greedy = AlgorithmFunc(func(ctx context.Context, client types.RepPoolClient, auctionRequest types.AuctionRequest, observer types.AuctionObserver) types.AuctionResult {
+	return voteAndClaim(ctx, client, auctionRequest, observer, auctionRequest.Rules.MaxBiddingPool)
+})
+
+// RoundRobin ignores scores and hands instances to the reps in turn, skipping those without room
+var RoundRobin Algorithm = &roundRobin{}
+
+var Algorithms = map[string]Algorithm{
+	"reserve-and-recast": ReserveAndRecast,
+	"power-of-two":       PowerOfTwoChoices,
+	"greedy":             Greedy,
+	"round-robin":        RoundRobin,
+}
+
+func LookupAlgorithm(name string) (Algorithm, error) {
+	if name == "" {
+		name = DefaultAlgorithm
+	}
+
+	algorithm, ok := Algorithms[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown algorithm %q (known: %v)", name, AlgorithmNames())
+	}
+
+	return algorithm, nil
+}
+
+func AlgorithmNames() []string {
+	names := []string{}
+	for name := range Algorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// voteAndClaim runs rounds in which poolSize random candidates vote and the best of them
+// reserves and claims straight away
+func voteAndClaim(ctx context.Context, client types.RepPoolClient, auctionRequest types.AuctionRequest, observer types.AuctionObserver, poolSize int) types.AuctionResult {
+	result := types.AuctionResult{Instance: auctionRequest.Instance}
+	candidates := auctionRequest.RepGuids
+
+	lastRoundFull := false
+	lostRounds := 0
+
+	t := time.Now()
+	for round := 1; round <= auctionRequest.Rules.MaxRounds && ctx.Err() == nil; round++ {
+		representatives := randomSubset(candidates, poolSize)
+		result.NumRounds++
+		roundErrors := map[string]int{}
+		result.RoundErrors = append(result.RoundErrors, roundErrors)
+		lastRoundFull = false
+		observer.RoundStarted(auctionRequest, round, representatives)
+
+		votes, err := client.Vote(ctx, representatives, auctionRequest.Instance)
+		result.NumVotes += len(representatives)
+		if tallyVoteErrors(roundErrors, votes) {
+			lostRounds++
+		}
+		observer.VotesReceived(auctionRequest, round, votes)
+		if err != nil {
+			continue
+		}
+
+		ineligible := ineligibleReps(votes)
+		if len(ineligible) > 0 {
+			candidates = without(candidates, ineligible)
+			if len(candidates) == 0 {
+				result.Outcome = types.OutcomeNoMatchingRepresentatives
+				result.Error = NoMatchingRepresentatives.Error()
+				break
+			}
+		}
+
+		winner, winningScore, err := pickWinner(votes, zonePenalties(votes, auctionRequest.Rules.ZoneBalanceWeight))
+		if err != nil {
+			lastRoundFull = allFull(votes)
+			continue
+		}
+		observer.WinnerChosen(auctionRequest, round, winner, winningScore)
+
+		hostPorts, err := reserveAndClaim(ctx, client, auctionRequest, round, winner, observer)
+		if err != nil {
+			roundErrors[err.Error()]++
+			if transportErrors[err.Error()] {
+				lostRounds++
+			}
+			continue
+		}
+
+		result.Winner = winner
+		result.Instance.HostPorts = hostPorts
+		break
+	}
+
+	return finish(ctx, result, t, lastRoundFull, lostRounds)
+}
+
+type roundRobin struct {
+	next uint64
+}
+
+func (r *roundRobin) Place(ctx context.Context, client types.RepPoolClient, auctionRequest types.AuctionRequest, observer types.AuctionObserver) types.AuctionResult {
+	result := types.AuctionResult{Instance: auctionRequest.Instance}
+	candidates := auctionRequest.RepGuids
+
+	//the candidates whose latest refusal was for lack of room
+	full := map[string]bool{}
+	lostRounds := 0
+
+	t := time.Now()
+	for round := 1; round <= auctionRequest.Rules.MaxRounds && ctx.Err() == nil; round++ {
+		if len(candidates) == 0 {
+			result.Outcome = types.OutcomeNoMatchingRepresentatives
+			result.Error = NoMatchingRepresentatives.Error()
+			break
+		}
+
+		rep := candidates[int(atomic.AddUint64(&r.next, 1)%uint64(len(candidates)))]
+		result.NumRounds++
+		result.NumVotes++
+		observer.RoundStarted(auctionRequest, round, []string{rep})
+		observer.WinnerChosen(auctionRequest, round, rep, 0)
+
+		hostPorts, err := reserveAndClaim(ctx, client, auctionRequest, round, rep, observer)
+		if err != nil {
+			result.RoundErrors = append(result.RoundErrors, map[string]int{err.Error(): 1})
+			if transportErrors[err.Error()] {
+				lostRounds++
+			}
+			if ineligibleVoteErrors[err.Error()] {
+				candidates = without(candidates, map[string]bool{rep: true})
+			}
+			if err == types.InsufficientResources {
+				full[rep] = true
+			} else {
+				delete(full, rep)
+			}
+			continue
+		}
+
+		result.RoundErrors = append(result.RoundErrors, map[string]int{})
+		result.Winner = rep
+		result.Instance.HostPorts = hostPorts
+		break
+	}
+
+	return finish(ctx, result, t, len(candidates) > 0 && len(full) == len(candidates), lostRounds)
+}
+
+// reserveAndClaim places the instance on rep outright.  As in the auction, a reservation
+// is released if the context ends before the claim, and a started claim is seen through.
+func reserveAndClaim(ctx context.Context, client types.RepPoolClient, auctionRequest types.AuctionRequest, round int, rep string, observer types.AuctionObserver) ([]int, error) {
+	score, err := client.ReserveAndRecastVote(ctx, rep, auctionRequest.Instance)
+	observer.ReserveCompleted(auctionRequest, round, rep, score, err)
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			release(client, auctionRequest, round, rep, observer)
+		}
+		return nil, err
+	}
+
+	hostPorts, err := client.Claim(context.Background(), rep, auctionRequest.Instance)
+	observer.ClaimCompleted(auctionRequest, round, rep, hostPorts, err)
+	return hostPorts, err
+}
+
+// release gives up a reservation regardless of the auction's context, a failed release is
+// reaped once the reservation expires
+func release(client types.RepPoolClient, auctionRequest types.AuctionRequest, round int, rep string, observer types.AuctionObserver) {
+	err := client.Release(context.Background(), rep, auctionRequest.Instance)
+	observer.Released(auctionRequest, round, rep, err)
+}
+
+// finish works out how the auction ended, unless a failure already said so.  lostRounds
+// counts the rounds that failed because the bidders could not be reached.
+func finish(ctx context.Context, result types.AuctionResult, t time.Time, lastRoundFull bool, lostRounds int) types.AuctionResult {
+	switch {
+	case result.Winner != "":
+		result.Outcome = types.OutcomePlaced
+	case result.Outcome != "":
+	case ctx.Err() != nil:
+		result.Outcome = contextOutcome(ctx)
+		result.Error = ctx.Err().Error()
+	case result.NumRounds > 0 && lostRounds == result.NumRounds:
+		result.Outcome = types.OutcomeTransportError
+		result.Error = BiddersUnreachable.Error()
+	case lastRoundFull:
+		result.Outcome = types.OutcomeAllBiddersFull
+		result.Error = AllBiddersFull.Error()
+	default:
+		result.Outcome = types.OutcomeRoundsExhausted
+		result.Error = RoundsExhausted.Error()
+	}
+
+	result.Duration = time.Since(t)
+	return result
+}
+
+func contextOutcome(ctx context.Context) types.AuctionOutcome {
+	if ctx.Err() == context.DeadlineExceeded {
+		return types.OutcomeTimedOut
+	}
+	return types.OutcomeCancelled
+}
+
+// tallyVoteErrors counts the votes' errors and reports whether every vote was lost in transit
+func tallyVoteErrors(counts map[string]int, votes []types.VoteResult) bool {
+	lost := len(votes) > 0
+	for _, vote := range votes {
+		if vote.Error != "" {
+			counts[vote.Error]++
+		}
+		if !transportErrors[vote.Error] {
+			lost = false
+		}
+	}
+
+	return lost
+}
@@ -0,0 +1,278 @@
+package auctioneer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cheggaaa/pb"
+	"github.com/onsi/auction/instance"
+	"github.com/onsi/auction/labels"
+	"github.com/onsi/auction/types"
+	"github.com/onsi/auction/util"
+)
+
+func HoldAppAuctionsFor(ctx context.Context, client types.RepPoolClient, requests []types.AppAuctionRequest, maxConcurrent int, observers ...types.AuctionObserver) ([]types.AppAuctionResult, time.Duration) {
+	fmt.Printf("\nStarting App Auctions\n\n")
+	bar := pb.StartNew(len(requests))
+
+	t := time.Now()
+	semaphore := make(chan bool, maxConcurrent)
+	c := make(chan types.AppAuctionResult)
+	for _, request := range requests {
+		go func(request types.AppAuctionRequest) {
+			semaphore <- true
+			c <- AppAuction(ctx, client, request, observers...)
+			<-semaphore
+		}(request)
+	}
+
+	results := []types.AppAuctionResult{}
+	for _ = range requests {
+		results = append(results, <-c)
+		bar.Increment()
+	}
+
+	bar.Finish()
+
+	return results, time.Since(t)
+}
+
+// AppAuction places all of an app's instances at once.  Each round the bidding pool
+// (grown to as many reps as there are instances left) votes on the whole batch, every rep
+// as if it took them all.  The instances are planned onto the reps one at a time wherever
+// the next one scores best, and each rep reserves and claims its share.  Instances that
+// fail to place go into the next round.
+//
+// Observers hear about the app's rounds as if it were a single auction of request.Instance,
+// then about each instance's reserve and claim, and finally that each instance's auction
+// completed.  The rounds and votes are only counted on the AppAuctionResult.
+func AppAuction(ctx context.Context, client types.RepPoolClient, request types.AppAuctionRequest, observers ...types.AuctionObserver) types.AppAuctionResult {
+	observer := Observers(observers)
+
+	result := appAuction(ctx, client, request, observer)
+
+	for _, placement := range result.Placements {
+		observer.AuctionCompleted(instanceRequest(request, placement.Instance), types.AuctionResult{
+			Instance: placement.Instance,
+			Winner:   placement.Rep,
+			Outcome:  types.OutcomePlaced,
+			Duration: result.Duration,
+		})
+	}
+	for _, inst := range result.Unplaced {
+		observer.AuctionCompleted(instanceRequest(request, inst), types.AuctionResult{
+			Instance: inst,
+			Outcome:  result.Outcome,
+			Duration: result.Duration,
+			Error:    result.Error,
+		})
+	}
+
+	return result
+}
+
+func appAuction(ctx context.Context, client types.RepPoolClient, request types.AppAuctionRequest, observer types.AuctionObserver) types.AppAuctionResult {
+	result := types.AppAuctionResult{AppGuid: request.Instance.AppGuid}
+	auctionRequest := instanceRequest(request, request.Instance)
+
+	pending := []instance.Instance{}
+	for i := 0; i < request.NumInstances; i++ {
+		inst := request.Instance
+		inst.InstanceGuid = util.NewGuid("INS")
+		inst.HostPorts = nil
+		pending = append(pending, inst)
+	}
+
+	_, err := labels.Parse(request.Instance.Constraint)
+	if err != nil {
+		result.Unplaced = pending
+		result.Outcome = types.OutcomeInvalidRequest
+		result.Error = err.Error()
+		return result
+	}
+
+	candidates := request.RepGuids
+	lastRoundFull := false
+
+	t := time.Now()
+	for round := 1; round <= request.Rules.MaxRounds && len(pending) > 0 && ctx.Err() == nil; round++ {
+		//an app can't spread over fewer reps than it has instances
+		poolSize := request.Rules.MaxBiddingPool
+		if len(pending) > poolSize {
+			poolSize = len(pending)
+		}
+		representatives := randomSubset(candidates, poolSize)
+		result.NumRounds++
+		observer.RoundStarted(auctionRequest, round, representatives)
+		votes := voteBatches(ctx, client, representatives, pending)
+		result.NumVotes += len(representatives)
+
+		firstVotes := []types.VoteResult{}
+		for _, rep := range representatives {
+			if len(votes[rep]) > 0 {
+				firstVotes = append(firstVotes, votes[rep][0])
+			}
+		}
+		observer.VotesReceived(auctionRequest, round, firstVotes)
+
+		ineligible := ineligibleReps(firstVotes)
+		if len(ineligible) > 0 {
+			candidates = without(candidates, ineligible)
+			representatives = without(representatives, ineligible)
+			if len(candidates) == 0 {
+				result.Outcome = types.OutcomeNoMatchingRepresentatives
+				result.Error = NoMatchingRepresentatives.Error()
+				break
+			}
+		}
+
+		plan, unplanned := planSpread(representatives, votes, pending, request.Rules.ZoneBalanceWeight)
+		lastRoundFull = len(plan) == 0 && allFull(firstVotes)
+		placements, failed, numReserves := claimPlan(ctx, client, request, plan, round, observer)
+		result.NumVotes += numReserves
+		result.Placements = append(result.Placements, placements...)
+		pending = append(unplanned, failed...)
+	}
+
+	result.Unplaced = pending
+	switch {
+	case len(pending) == 0:
+		result.Outcome = types.OutcomePlaced
+	case result.Outcome != "":
+	case ctx.Err() != nil:
+		result.Outcome = contextOutcome(ctx)
+		result.Error = ctx.Err().Error()
+	case lastRoundFull:
+		result.Outcome = types.OutcomeAllBiddersFull
+		result.Error = AllBiddersFull.Error()
+	default:
+		result.Outcome = types.OutcomeRoundsExhausted
+		result.Error = RoundsExhausted.Error()
+	}
+	result.Duration = time.Since(t)
+
+	return result
+}
+
+// instanceRequest is the auction request observers see for one of the app's instances
+func instanceRequest(request types.AppAuctionRequest, inst instance.Instance) types.AuctionRequest {
+	return types.AuctionRequest{
+		Instance: inst,
+		RepGuids: request.RepGuids,
+		Rules:    request.Rules,
+	}
+}
+
+// voteBatches collects each rep's batch vote, reps that fail to vote get no votes
+func voteBatches(ctx context.Context, client types.RepPoolClient, representatives []string, instances []instance.Instance) map[string][]types.VoteResult {
+	lock := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	votes := map[string][]types.VoteResult{}
+	for _, rep := range representatives {
+		wg.Add(1)
+		go func(rep string) {
+			defer wg.Done()
+			results, err := client.VoteBatch(ctx, rep, instances)
+			if err != nil || len(results) != len(instances) {
+				return
+			}
+			lock.Lock()
+			votes[rep] = results
+			lock.Unlock()
+		}(rep)
+	}
+	wg.Wait()
+
+	return votes
+}
+
+// planSpread hands the instances out one at a time to whichever rep's next vote (its vote
+// for taking one more of the app) is lowest, after penalizing zones by the instances planned
+// so far.  As with zonePenalties, a zone's load is estimated from the bidders alone.  A rep
+// is done once it votes with an error.
+func planSpread(representatives []string, votes map[string][]types.VoteResult, instances []instance.Instance, zoneBalanceWeight float64) (map[string][]instance.Instance, []instance.Instance) {
+	zoneInstances := map[string]int{}
+	zoneBidders := map[string]int{}
+	for _, rep := range representatives {
+		if len(votes[rep]) == 0 || votes[rep][0].Error != "" {
+			continue
+		}
+		zoneInstances[votes[rep][0].Zone] += votes[rep][0].AppInstances
+		zoneBidders[votes[rep][0].Zone]++
+	}
+
+	zonePenalty := func(zone string) float64 {
+		if zoneBalanceWeight == 0 || len(zoneBidders) < 2 {
+			return 0
+		}
+		minMean := -1.0
+		for z, bidders := range zoneBidders {
+			mean := float64(zoneInstances[z]) / float64(bidders)
+			if minMean < 0 || mean < minMean {
+				minMean = mean
+			}
+		}
+		return zoneBalanceWeight * (float64(zoneInstances[zone])/float64(zoneBidders[zone]) - minMean)
+	}
+
+	plan := map[string][]instance.Instance{}
+	for i, inst := range instances {
+		winner, winningScore := "", 0.0
+		for _, rep := range representatives {
+			next := len(plan[rep])
+			if next >= len(votes[rep]) || votes[rep][next].Error != "" {
+				continue
+			}
+			score := votes[rep][next].Score + zonePenalty(votes[rep][next].Zone)
+			if winner == "" || score < winningScore {
+				winner, winningScore = rep, score
+			}
+		}
+
+		if winner == "" {
+			return plan, instances[i:]
+		}
+
+		plan[winner] = append(plan[winner], inst)
+		zoneInstances[votes[winner][0].Zone]++
+	}
+
+	return plan, nil
+}
+
+// claimPlan has every rep reserve and claim its share of the plan.  Once a rep fails to
+// place an instance the rest of its share is left for the next round.
+//
+// There is no batched reserve or claim, so a rep's share still costs two round trips per
+// instance, made one after the other (the reps themselves are claimed on in parallel).
+func claimPlan(ctx context.Context, client types.RepPoolClient, request types.AppAuctionRequest, plan map[string][]instance.Instance, round int, observer types.AuctionObserver) ([]types.AppPlacement, []instance.Instance, int) {
+	lock := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	placements := []types.AppPlacement{}
+	failed := []instance.Instance{}
+	numReserves := 0
+	for rep, instances := range plan {
+		wg.Add(1)
+		go func(rep string, instances []instance.Instance) {
+			defer wg.Done()
+			for i, inst := range instances {
+				hostPorts, err := reserveAndClaim(ctx, client, instanceRequest(request, inst), round, rep, observer)
+				lock.Lock()
+				numReserves++
+				if err != nil {
+					failed = append(failed, instances[i:]...)
+					lock.Unlock()
+					return
+				}
+				inst.HostPorts = hostPorts
+				placements = append(placements, types.AppPlacement{Instance: inst, Rep: rep})
+				lock.Unlock()
+			}
+		}(rep, instances)
+	}
+	wg.Wait()
+
+	return placements, failed, numReserves
+}
@@ -1,28 +1,51 @@
 package auctioneer
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/cheggaaa/pb"
 	"github.com/cloudfoundry/yagnats"
 	"github.com/onsi/auction/instance"
+	"github.com/onsi/auction/labels"
 	"github.com/onsi/auction/types"
 	"github.com/onsi/auction/util"
 )
 
 var AllBiddersFull = errors.New("all the bidders were full")
+var NoMatchingRepresentatives = errors.New("no representative can run the instance")
+var RoundsExhausted = errors.New("ran out of rounds")
+var BiddersUnreachable = errors.New("the bidders could not be reached")
+
+// reps that refuse to vote for these reasons will never be able to run the instance
+var ineligibleVoteErrors = map[string]bool{
+	types.IncompatibleStack.Error():  true,
+	types.ConstraintMismatch.Error(): true,
+	types.Draining.Error():           true,
+}
+
+// requests that fail for these reasons never reached the rep, or its reply never came back
+var transportErrors = map[string]bool{
+	types.TimeoutError.Error():       true,
+	types.RequestFailedError.Error(): true,
+}
+
+// how long RemoteAuction waits for a result when the context has no deadline of its own
+var DefaultRemoteAuctionTimeout = time.Minute
 
 var DefaultRules = types.AuctionRules{
-	MaxRounds:        100,
-	MaxBiddingPool:   20,
-	MaxConcurrent:    20,
-	RepickEveryRound: true,
+	MaxRounds:         100,
+	MaxBiddingPool:    20,
+	MaxConcurrent:     20,
+	RepickEveryRound:  true,
+	ZoneBalanceWeight: 1,
 }
 
-func HoldAuctionsFor(client types.RepPoolClient, instances []instance.Instance, representatives []string, rules types.AuctionRules, communicator types.AuctionCommunicator) ([]types.AuctionResult, time.Duration) {
+func HoldAuctionsFor(ctx context.Context, client types.RepPoolClient, instances []instance.Instance, representatives []string, rules types.AuctionRules, communicator types.AuctionCommunicator, observers ...types.AuctionObserver) ([]types.AuctionResult, time.Duration) {
 	fmt.Printf("\nStarting Auctions\n\n")
 	bar := pb.StartNew(len(instances))
 
@@ -32,11 +55,11 @@ func HoldAuctionsFor(client types.RepPoolClient, instances []instance.Instance,
 	for _, inst := range instances {
 		go func(inst instance.Instance) {
 			semaphore <- true
-			c <- communicator(types.AuctionRequest{
+			c <- communicator(ctx, types.AuctionRequest{
 				Instance: inst,
 				RepGuids: representatives,
 				Rules:    rules,
-			})
+			}, Observers(observers))
 			<-semaphore
 		}(inst)
 	}
@@ -52,58 +75,197 @@ func HoldAuctionsFor(client types.RepPoolClient, instances []instance.Instance,
 	return results, time.Since(t)
 }
 
-func RemoteAuction(client yagnats.NATSClient, auctionRequest types.AuctionRequest) types.AuctionResult {
+// Evacuate drains a representative and re-auctions everything it hosts onto the
+// remaining representatives.  The old copy of an instance is only released once its
+// replacement has been claimed, so instances that fail to place keep running where they are.
+func Evacuate(ctx context.Context, client types.RepPoolClient, guid string, representatives []string, rules types.AuctionRules, observers ...types.AuctionObserver) ([]types.AuctionResult, error) {
+	instances, err := client.Drain(ctx, guid)
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := without(representatives, map[string]bool{guid: true})
+
+	semaphore := make(chan bool, rules.MaxConcurrent)
+	c := make(chan types.AuctionResult)
+	for _, inst := range instances {
+		go func(inst instance.Instance) {
+			semaphore <- true
+			result := Auction(ctx, client, types.AuctionRequest{
+				Instance: inst,
+				RepGuids: remaining,
+				Rules:    rules,
+			}, observers...)
+			if result.Winner != "" {
+				//the replacement is claimed, so see the release through even if ctx is done
+				err := client.ReleaseEvacuated(context.Background(), guid, inst)
+				if err != nil {
+					result.Error = fmt.Sprintf("placed on %s but failed to release from %s: %s", result.Winner, guid, err.Error())
+				}
+			}
+			c <- result
+			<-semaphore
+		}(inst)
+	}
+
+	results := []types.AuctionResult{}
+	for _ = range instances {
+		results = append(results, <-c)
+	}
+
+	return results, nil
+}
+
+// RemoteAuction asks an auctioneer node to run the auction.  The context's deadline (or
+// DefaultRemoteAuctionTimeout) travels with the request so the node gives up when we do.
+// The rounds happen remotely, so observers only hear that the auction completed.
+// Nothing tells the node when ctx is done early, and the node may have placed the
+// instance by then, so giving up on it reports OutcomeUnknown rather than cancelled
+// or timed out.
+func RemoteAuction(ctx context.Context, client yagnats.NATSClient, auctionRequest types.AuctionRequest, observers ...types.AuctionObserver) types.AuctionResult {
+	result := remoteAuction(ctx, client, auctionRequest)
+	Observers(observers).AuctionCompleted(auctionRequest, result)
+	return result
+}
+
+func remoteAuction(ctx context.Context, client yagnats.NATSClient, auctionRequest types.AuctionRequest) types.AuctionResult {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DefaultRemoteAuctionTimeout)
+		defer cancel()
+	}
+	auctionRequest.Deadline, _ = ctx.Deadline()
+
 	guid := util.RandomGuid()
 	payload, _ := json.Marshal(auctionRequest)
 
-	c := make(chan []byte)
-	client.Subscribe(guid, func(msg *yagnats.Message) {
-		c <- msg.Payload
+	c := make(chan []byte, 1)
+	subscription, err := client.Subscribe(guid, func(msg *yagnats.Message) {
+		select {
+		case c <- msg.Payload:
+		default:
+		}
 	})
+	if err != nil {
+		return types.AuctionResult{Instance: auctionRequest.Instance, Outcome: types.OutcomeTransportError, Error: err.Error()}
+	}
+	defer client.Unsubscribe(subscription)
 
 	client.PublishWithReplyTo("diego.auction", guid, payload)
 
 	var responsePayload []byte
 	select {
 	case responsePayload = <-c:
-	case <-time.After(time.Minute):
-		return types.AuctionResult{}
+	case <-ctx.Done():
+		return types.AuctionResult{Instance: auctionRequest.Instance, Outcome: types.OutcomeUnknown, Error: fmt.Sprintf("gave up waiting for the auctioneer: %s", ctx.Err().Error())}
 	}
 
 	var auctionResult types.AuctionResult
-	err := json.Unmarshal(responsePayload, &auctionResult)
+	err = json.Unmarshal(responsePayload, &auctionResult)
 	if err != nil {
-		panic(err)
+		return types.AuctionResult{Instance: auctionRequest.Instance, Outcome: types.OutcomeTransportError, Error: fmt.Sprintf("invalid auction result: %s", err.Error())}
 	}
 
 	return auctionResult
 }
 
-func Auction(client types.RepPoolClient, auctionRequest types.AuctionRequest) types.AuctionResult {
+// Auction places the instance with the request's algorithm (reserve-and-recast by default),
+// telling the observers about each step.  Requests with an unknown algorithm or an invalid
+// constraint fail before any rep is asked.
+func Auction(ctx context.Context, client types.RepPoolClient, auctionRequest types.AuctionRequest, observers ...types.AuctionObserver) types.AuctionResult {
+	observer := Observers(observers)
+
+	var result types.AuctionResult
+	algorithm, err := LookupAlgorithm(auctionRequest.Algorithm)
+	if err == nil {
+		_, err = labels.Parse(auctionRequest.Instance.Constraint)
+	}
+
+	if err != nil {
+		result = types.AuctionResult{Instance: auctionRequest.Instance, Outcome: types.OutcomeInvalidRequest, Error: err.Error()}
+	} else {
+		result = algorithm.Place(ctx, client, auctionRequest, observer)
+	}
+
+	observer.AuctionCompleted(auctionRequest, result)
+	return result
+}
+
+// reserveAndRecast runs rounds until the instance is placed, the rules give up or the context
+// is done.  Each round the best bidder reserves and recasts its vote, and only claims if it
+// still beats the rest of the pool.  A reservation held when the context ends is released.
+func reserveAndRecast(ctx context.Context, client types.RepPoolClient, auctionRequest types.AuctionRequest, observer types.AuctionObserver) types.AuctionResult {
+	result := types.AuctionResult{Instance: auctionRequest.Instance}
 	var auctionWinner string
+	var preempted []instance.Instance
+	lastRoundFull := false
+	lostRounds := 0
 
 	var representatives []string
 
+	candidates := auctionRequest.RepGuids
+
 	if !auctionRequest.Rules.RepickEveryRound {
-		representatives = randomSubset(auctionRequest.RepGuids, auctionRequest.Rules.MaxBiddingPool)
+		representatives = randomSubset(candidates, auctionRequest.Rules.MaxBiddingPool)
 	}
 
 	numRounds, numVotes := 0, 0
 	t := time.Now()
 	for round := 1; round <= auctionRequest.Rules.MaxRounds; round++ {
-		if auctionRequest.Rules.RepickEveryRound {
-			representatives = randomSubset(auctionRequest.RepGuids, auctionRequest.Rules.MaxBiddingPool)
+		if ctx.Err() != nil {
+			break
+		}
+		if auctionRequest.Rules.RepickEveryRound || len(representatives) == 0 {
+			representatives = randomSubset(candidates, auctionRequest.Rules.MaxBiddingPool)
 		}
 		numRounds++
-		winner, _, err := vote(client, auctionRequest.Instance, representatives)
+		roundErrors := map[string]int{}
+		result.RoundErrors = append(result.RoundErrors, roundErrors)
+		lastRoundFull = false
+		observer.RoundStarted(auctionRequest, round, representatives)
+
+		firstRoundVotes, err := client.Vote(ctx, representatives, auctionRequest.Instance)
 		numVotes += len(representatives)
+		if tallyVoteErrors(roundErrors, firstRoundVotes) {
+			lostRounds++
+		}
+		observer.VotesReceived(auctionRequest, round, firstRoundVotes)
 		if err != nil {
 			continue
 		}
 
+		ineligible := ineligibleReps(firstRoundVotes)
+		if len(ineligible) > 0 {
+			candidates = without(candidates, ineligible)
+			representatives = without(representatives, ineligible)
+			if len(candidates) == 0 {
+				result.Outcome = types.OutcomeNoMatchingRepresentatives
+				result.Error = NoMatchingRepresentatives.Error()
+				break
+			}
+		}
+
+		penalties := zonePenalties(firstRoundVotes, auctionRequest.Rules.ZoneBalanceWeight)
+		winner, winningScore, err := pickWinner(firstRoundVotes, penalties)
+		if err != nil {
+			lastRoundFull = allFull(firstRoundVotes)
+			if auctionRequest.Rules.AllowPreemption && allFull(firstRoundVotes) {
+				var stopped []instance.Instance
+				auctionWinner, auctionRequest.Instance.HostPorts, stopped = preempt(ctx, client, representatives, auctionRequest, round, observer)
+				preempted = append(preempted, stopped...)
+				if auctionWinner != "" {
+					break
+				}
+			}
+			continue
+		}
+		observer.WinnerChosen(auctionRequest, round, winner, winningScore)
+
+		var secondPlaceScore float64
+
 		c := make(chan types.VoteResult)
 		go func() {
-			winnerScore, err := client.ReserveAndRecastVote(winner, auctionRequest.Instance)
+			winnerScore, err := client.ReserveAndRecastVote(ctx, winner, auctionRequest.Instance)
 			result := types.VoteResult{
 				Rep: winner,
 			}
@@ -116,41 +278,176 @@ func Auction(client types.RepPoolClient, auctionRequest types.AuctionRequest) ty
 			c <- result
 		}()
 
-		secondRoundVoters := []string{}
+		secondRoundVoters := secondRoundBidders(representatives, firstRoundVotes, penalties, winner, auctionRequest.Rules.SecondRoundTopK)
 
-		for _, rep := range representatives {
-			if rep != winner {
-				secondRoundVoters = append(secondRoundVoters, rep)
-			}
+		secondRoundVotes, err := client.Vote(ctx, secondRoundVoters, auctionRequest.Instance)
+		tallyVoteErrors(roundErrors, secondRoundVotes)
+		if err == nil {
+			_, secondPlaceScore, err = pickWinner(secondRoundVotes, penalties)
 		}
 
-		_, secondPlaceScore, err := vote(client, auctionRequest.Instance, secondRoundVoters)
-
 		winnerRecast := <-c
-		numVotes += len(representatives)
+		numVotes += 1 + len(secondRoundVoters)
+		observer.ReserveCompleted(auctionRequest, round, winner, winnerRecast.Score, types.ErrorFromString(winnerRecast.Error))
+		observer.SecondRoundCompleted(auctionRequest, round, secondRoundVotes, secondPlaceScore, err)
 
 		if winnerRecast.Error != "" {
+			roundErrors[winnerRecast.Error]++
+			if transportErrors[winnerRecast.Error] {
+				lostRounds++
+			}
+			if ctx.Err() != nil {
+				//the reservation may have been made before we stopped waiting for it
+				release(client, auctionRequest, round, winner, observer)
+			}
 			//winner ran out of space on the recast, retry
 			continue
 		}
 
-		if err == nil && secondPlaceScore < winnerRecast.Score && round < auctionRequest.Rules.MaxRounds {
-			client.Release(winner, auctionRequest.Instance)
+		if ctx.Err() != nil || (err == nil && secondPlaceScore < winnerRecast.Score+penalties[winner] && round < auctionRequest.Rules.MaxRounds) {
+			//a failed release is reaped once the reservation expires
+			release(client, auctionRequest, round, winner, observer)
 			continue
 		}
 
-		client.Claim(winner, auctionRequest.Instance)
+		//once we commit to claiming, see it through so we know whether the instance is placed
+		hostPorts, err := client.Claim(context.Background(), winner, auctionRequest.Instance)
+		observer.ClaimCompleted(auctionRequest, round, winner, hostPorts, err)
+		if err != nil {
+			roundErrors[err.Error()]++
+			if transportErrors[err.Error()] {
+				lostRounds++
+			}
+			//the reservation may have expired, try again
+			continue
+		}
+
+		auctionRequest.Instance.HostPorts = hostPorts
+
 		auctionWinner = winner
 		break
 	}
 
-	return types.AuctionResult{
-		Winner:    auctionWinner,
-		Instance:  auctionRequest.Instance,
-		NumRounds: numRounds,
-		NumVotes:  numVotes,
-		Duration:  time.Since(t),
+	result.Winner = auctionWinner
+	result.Instance = auctionRequest.Instance
+	result.NumRounds = numRounds
+	result.NumVotes = numVotes
+	result.Preempted = preempted
+
+	return finish(ctx, result, t, lastRoundFull, lostRounds)
+}
+
+// preempt asks each bidder in turn to make room for the instance by stopping lower
+// priority instances.  It returns the winner (if any), the host ports the winner assigned
+// and everything that was stopped.
+func preempt(ctx context.Context, client types.RepPoolClient, representatives []string, auctionRequest types.AuctionRequest, round int, observer types.AuctionObserver) (string, []int, []instance.Instance) {
+	inst := auctionRequest.Instance
+	preempted := []instance.Instance{}
+	for _, index := range util.R.Perm(len(representatives)) {
+		if ctx.Err() != nil {
+			break
+		}
+
+		rep := representatives[index]
+		stopped, err := client.Preempt(ctx, rep, inst)
+		preempted = append(preempted, stopped...)
+		observer.ReserveCompleted(auctionRequest, round, rep, 0, err)
+		if err != nil {
+			if ctx.Err() != nil {
+				//the rep may have made room and reserved before we stopped waiting
+				release(client, auctionRequest, round, rep, observer)
+			}
+			continue
+		}
+
+		hostPorts, err := client.Claim(context.Background(), rep, inst)
+		observer.ClaimCompleted(auctionRequest, round, rep, hostPorts, err)
+		if err != nil {
+			release(client, auctionRequest, round, rep, observer)
+			continue
+		}
+
+		return rep, hostPorts, preempted
+	}
+
+	return "", nil, preempted
+}
+
+// secondRoundBidders picks who votes against the winner's recast: the topK best scoring
+// first round bidders, or every other bidder when topK is 0
+func secondRoundBidders(representatives []string, votes []types.VoteResult, penalties map[string]float64, winner string, topK int) []string {
+	if topK <= 0 {
+		return without(representatives, map[string]bool{winner: true})
+	}
+
+	ranked := []types.VoteResult{}
+	for _, vote := range votes {
+		if vote.Error == "" && vote.Rep != winner {
+			ranked = append(ranked, vote)
+		}
+	}
+
+	sort.Sort(byPenalizedScore{ranked, penalties})
+
+	if len(ranked) > topK {
+		ranked = ranked[:topK]
 	}
+
+	reps := []string{}
+	for _, vote := range ranked {
+		reps = append(reps, vote.Rep)
+	}
+
+	return reps
+}
+
+type byPenalizedScore struct {
+	votes     []types.VoteResult
+	penalties map[string]float64
+}
+
+func (s byPenalizedScore) Len() int {
+	return len(s.votes)
+}
+
+func (s byPenalizedScore) Swap(i, j int) {
+	s.votes[i], s.votes[j] = s.votes[j], s.votes[i]
+}
+
+func (s byPenalizedScore) Less(i, j int) bool {
+	return s.votes[i].Score+s.penalties[s.votes[i].Rep] < s.votes[j].Score+s.penalties[s.votes[j].Rep]
+}
+
+func allFull(results []types.VoteResult) bool {
+	for _, result := range results {
+		if result.Error != types.InsufficientResources.Error() {
+			return false
+		}
+	}
+
+	return len(results) > 0
+}
+
+func ineligibleReps(results []types.VoteResult) map[string]bool {
+	ineligible := map[string]bool{}
+	for _, result := range results {
+		if ineligibleVoteErrors[result.Error] {
+			ineligible[result.Rep] = true
+		}
+	}
+
+	return ineligible
+}
+
+func without(representatives []string, excluded map[string]bool) []string {
+	reps := []string{}
+	for _, rep := range representatives {
+		if !excluded[rep] {
+			reps = append(reps, rep)
+		}
+	}
+
+	return reps
 }
 
 func randomSubset(representatives []string, subsetSize int) []string {
@@ -166,9 +463,8 @@ func randomSubset(representatives []string, subsetSize int) []string {
 	return reps
 }
 
-func vote(client types.RepPoolClient, instance instance.Instance, representatives []string) (string, float64, error) {
-	results := client.Vote(representatives, instance)
-
+// pickWinner picks the lowest scoring vote, after adding each rep's zone penalty
+func pickWinner(results []types.VoteResult, penalties map[string]float64) (string, float64, error) {
 	winningScore := 1e9
 	winners := []string{}
 
@@ -177,10 +473,12 @@ func vote(client types.RepPoolClient, instance instance.Instance, representative
 			continue
 		}
 
-		if result.Score < winningScore {
-			winningScore = result.Score
+		score := result.Score + penalties[result.Rep]
+
+		if score < winningScore {
+			winningScore = score
 			winners = []string{result.Rep}
-		} else if result.Score == winningScore { // can be less strict here
+		} else if score == winningScore { // can be less strict here
 			winners = append(winners, result.Rep)
 		}
 	}
@@ -193,3 +491,45 @@ func vote(client types.RepPoolClient, instance instance.Instance, representative
 
 	return winner, winningScore, nil
 }
+
+// zonePenalties penalizes reps in zones whose bidders run more instances of the app
+// (on average) than the bidders in the least loaded zone.  The same penalties apply to
+// every vote in a round so that the second round compares like with like.
+//
+// Only the round's bidders are counted, so a zone's load is estimated from a sample of
+// its reps rather than all of them.  With a small bidding pool (or a zone with a single
+// bidder) the estimate is rough; counting every rep would cost a vote from each per round.
+func zonePenalties(results []types.VoteResult, weight float64) map[string]float64 {
+	zoneInstances := map[string]int{}
+	zoneBidders := map[string]int{}
+	for _, result := range results {
+		if result.Error != "" {
+			continue
+		}
+		zoneInstances[result.Zone] += result.AppInstances
+		zoneBidders[result.Zone]++
+	}
+
+	penalties := map[string]float64{}
+	if weight == 0 || len(zoneBidders) < 2 {
+		return penalties
+	}
+
+	zoneMeans := map[string]float64{}
+	minMean := -1.0
+	for zone, bidders := range zoneBidders {
+		zoneMeans[zone] = float64(zoneInstances[zone]) / float64(bidders)
+		if minMean < 0 || zoneMeans[zone] < minMean {
+			minMean = zoneMeans[zone]
+		}
+	}
+
+	for _, result := range results {
+		if result.Error != "" {
+			continue
+		}
+		penalties[result.Rep] = weight * (zoneMeans[result.Zone] - minMean)
+	}
+
+	return penalties
+}
@@ -0,0 +1,81 @@
+package auctioneer
+
+import "github.com/onsi/auction/types"
+
+// NoopObserver ignores every event, embed it to observe only some of them
+type NoopObserver struct{}
+
+func (NoopObserver) RoundStarted(request types.AuctionRequest, round int, bidders []string) {
+}
+
+func (NoopObserver) VotesReceived(request types.AuctionRequest, round int, votes []types.VoteResult) {
+}
+
+func (NoopObserver) WinnerChosen(request types.AuctionRequest, round int, winner string, score float64) {
+}
+
+func (NoopObserver) ReserveCompleted(request types.AuctionRequest, round int, rep string, score float64, err error) {
+}
+
+func (NoopObserver) SecondRoundCompleted(request types.AuctionRequest, round int, votes []types.VoteResult, secondPlaceScore float64, err error) {
+}
+
+func (NoopObserver) Released(request types.AuctionRequest, round int, rep string, err error) {
+}
+
+func (NoopObserver) ClaimCompleted(request types.AuctionRequest, round int, rep string, hostPorts []int, err error) {
+}
+
+func (NoopObserver) AuctionCompleted(request types.AuctionRequest, result types.AuctionResult) {
+}
+
+// Observers passes every event on to each of its observers in turn
+type Observers []types.AuctionObserver
+
+func (observers Observers) RoundStarted(request types.AuctionRequest, round int, bidders []string) {
+	for _, observer := range observers {
+		observer.RoundStarted(request, round, bidders)
+	}
+}
+
+func (observers Observers) VotesReceived(request types.AuctionRequest, round int, votes []types.VoteResult) {
+	for _, observer := range observers {
+		observer.VotesReceived(request, round, votes)
+	}
+}
+
+func (observers Observers) WinnerChosen(request types.AuctionRequest, round int, winner string, score float64) {
+	for _, observer := range observers {
+		observer.WinnerChosen(request, round, winner, score)
+	}
+}
+
+func (observers Observers) ReserveCompleted(request types.AuctionRequest, round int, rep string, score float64, err error) {
+	for _, observer := range observers {
+		observer.ReserveCompleted(request, round, rep, score, err)
+	}
+}
+
+func (observers Observers) SecondRoundCompleted(request types.AuctionRequest, round int, votes []types.VoteResult, secondPlaceScore float64, err error) {
+	for _, observer := range observers {
+		observer.SecondRoundCompleted(request, round, votes, secondPlaceScore, err)
+	}
+}
+
+func (observers Observers) Released(request types.AuctionRequest, round int, rep string, err error) {
+	for _, observer := range observers {
+		observer.Released(request, round, rep, err)
+	}
+}
+
+func (observers Observers) ClaimCompleted(request types.AuctionRequest, round int, rep string, hostPorts []int, err error) {
+	for _, observer := range observers {
+		observer.ClaimCompleted(request, round, rep, hostPorts, err)
+	}
+}
+
+func (observers Observers) AuctionCompleted(request types.AuctionRequest, result types.AuctionResult) {
+	for _, observer := range observers {
+		observer.AuctionCompleted(request, result)
+	}
+}
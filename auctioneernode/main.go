@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -60,7 +61,14 @@ func main() {
 			return
 		}
 
-		auctionResult := auctioneer.Auction(repclient, auctionRequest)
+		ctx := context.Background()
+		if !auctionRequest.Deadline.IsZero() {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, auctionRequest.Deadline)
+			defer cancel()
+		}
+
+		auctionResult := auctioneer.Auction(ctx, repclient, auctionRequest)
 		payload, _ := json.Marshal(auctionResult)
 
 		client.Publish(msg.ReplyTo, payload)
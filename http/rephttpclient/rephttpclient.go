@@ -2,8 +2,10 @@ package rephttpclient
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"errors"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"time"
 
@@ -14,6 +16,9 @@ import (
 var semaphore chan bool
 var MaxConcurrentConnections = 10
 
+// MaxRetries is how many times reserves, claims and releases are retried after timing out
+var MaxRetries = 2
+
 func init() {
 	semaphore = make(chan bool, MaxConcurrentConnections)
 }
@@ -43,118 +48,133 @@ func (rep *RepHTTPClient) exit() {
 	<-semaphore
 }
 
-func (rep *RepHTTPClient) TotalResources(guid string) int {
+func (rep *RepHTTPClient) requestWithRetries(ctx context.Context, guid string, path string, req interface{}, resp interface{}) error {
+	return types.RetryTimeouts(MaxRetries, func() error {
+		return rep.request(ctx, guid, path, req, resp)
+	})
+}
+
+// request GETs the rep's endpoint (or POSTs req as JSON, if there is one) and decodes the response into resp, if there is one
+func (rep *RepHTTPClient) request(ctx context.Context, guid string, path string, req interface{}, resp interface{}) error {
 	rep.enter()
 	defer rep.exit()
 
-	resp, err := rep.client.Get(rep.endpoints[guid] + "/total_resources")
-	if err != nil {
-		panic("failed to get total resources!")
-	}
+	var request *http.Request
+	var err error
+	if req == nil {
+		request, err = http.NewRequest("GET", rep.endpoints[guid]+path, nil)
+	} else {
+		body := new(bytes.Buffer)
+		err = json.NewEncoder(body).Encode(req)
+		if err != nil {
+			return err
+		}
 
-	defer resp.Body.Close()
+		request, err = http.NewRequest("POST", rep.endpoints[guid]+path, body)
+		if err == nil {
+			request.Header.Set("Content-Type", "application/json")
+		}
+	}
 
-	var totalResources int
-	err = json.NewDecoder(resp.Body).Decode(&totalResources)
 	if err != nil {
-		panic("invalid total resources: " + err.Error())
+		return err
 	}
 
-	return totalResources
-}
-
-func (rep *RepHTTPClient) Instances(guid string) []instance.Instance {
-	rep.enter()
-	defer rep.exit()
-
-	resp, err := rep.client.Get(rep.endpoints[guid] + "/instances")
+	response, err := rep.client.Do(request.WithContext(ctx))
 	if err != nil {
-		panic("failed to get instances!")
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return types.TimeoutError
+		}
+		return err
 	}
 
-	defer resp.Body.Close()
+	defer response.Body.Close()
 
-	var instances []instance.Instance
-	err = json.NewDecoder(resp.Body).Decode(&instances)
-	if err != nil {
-		panic("invalid instances: " + err.Error())
+	if response.StatusCode != http.StatusOK {
+		reason, err := ioutil.ReadAll(response.Body)
+		if err != nil || len(bytes.TrimSpace(reason)) == 0 {
+			return types.RequestFailedError
+		}
+		return types.ErrorFromString(string(bytes.TrimSpace(reason)))
 	}
 
-	return instances
-}
+	if resp == nil {
+		return nil
+	}
 
-func (rep *RepHTTPClient) Reset(guid string) {
-	rep.enter()
-	defer rep.exit()
-	rep.client.Get(rep.endpoints[guid] + "/reset")
+	return json.NewDecoder(response.Body).Decode(resp)
 }
 
-func (rep *RepHTTPClient) SetInstances(guid string, instances []instance.Instance) {
-	rep.enter()
-	defer rep.exit()
+func (rep *RepHTTPClient) TotalResources(guid string) (instance.Resources, error) {
+	var totalResources instance.Resources
+	err := rep.request(context.Background(), guid, "/total_resources", nil, &totalResources)
+	return totalResources, err
+}
 
-	body := new(bytes.Buffer)
-	err := json.NewEncoder(body).Encode(instances)
-	if err != nil {
-		println(err.Error())
-		return
-	}
+func (rep *RepHTTPClient) Capacity(guid string) (instance.Resources, error) {
+	var capacity instance.Resources
+	err := rep.request(context.Background(), guid, "/capacity", nil, &capacity)
+	return capacity, err
+}
 
-	resp, err := rep.client.Post(rep.endpoints[guid]+"/set_instances", "application/json", body)
-	if err != nil {
-		println(err.Error())
-		return
-	}
+func (rep *RepHTTPClient) State(guid string) (types.RepState, error) {
+	var state types.RepState
+	err := rep.request(context.Background(), guid, "/state", nil, &state)
+	return state, err
+}
 
-	resp.Body.Close()
+func (rep *RepHTTPClient) SetTotalResources(guid string, request types.ResizeRequest) (types.ResizeResult, error) {
+	var result types.ResizeResult
+	err := rep.request(context.Background(), guid, "/set_total_resources", request, &result)
+	return result, err
 }
 
-func (rep *RepHTTPClient) vote(guid string, instance instance.Instance, c chan types.VoteResult) {
-	rep.enter()
-	defer rep.exit()
-	result := types.VoteResult{
-		Rep: guid,
-	}
-	defer func() {
-		c <- result
-	}()
+func (rep *RepHTTPClient) Zone(guid string) (string, error) {
+	var zone string
+	err := rep.request(context.Background(), guid, "/zone", nil, &zone)
+	return zone, err
+}
 
-	body := new(bytes.Buffer)
-	err := json.NewEncoder(body).Encode(instance)
-	if err != nil {
-		result.Error = err.Error()
-		return
-	}
+func (rep *RepHTTPClient) ReapedReservations(guid string) (int, error) {
+	var reaped int
+	err := rep.request(context.Background(), guid, "/reaped_reservations", nil, &reaped)
+	return reaped, err
+}
 
-	resp, err := rep.client.Post(rep.endpoints[guid]+"/vote", "application/json", body)
-	if err != nil {
-		println(err.Error())
-		result.Error = err.Error()
-		return
-	}
+func (rep *RepHTTPClient) Instances(guid string) ([]instance.Instance, error) {
+	var instances []instance.Instance
+	err := rep.request(context.Background(), guid, "/instances", nil, &instances)
+	return instances, err
+}
 
-	defer resp.Body.Close()
+func (rep *RepHTTPClient) Reset(guid string) error {
+	return rep.request(context.Background(), guid, "/reset", nil, nil)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		result.Error = "failed"
-		return
-	}
+func (rep *RepHTTPClient) SetInstances(guid string, instances []instance.Instance) error {
+	return rep.request(context.Background(), guid, "/set_instances", instances, nil)
+}
 
-	var score float64
-	err = json.NewDecoder(resp.Body).Decode(&score)
+func (rep *RepHTTPClient) vote(ctx context.Context, guid string, instance instance.Instance, c chan types.VoteResult) {
+	var result types.VoteResult
+	err := rep.request(ctx, guid, "/vote", instance, &result)
 	if err != nil {
-		result.Error = err.Error()
-		return
+		result = types.VoteResult{
+			Rep:   guid,
+			Error: err.Error(),
+		}
 	}
-	result.Score = score
 
-	return
+	c <- result
 }
 
-func (rep *RepHTTPClient) Vote(guids []string, instance instance.Instance) []types.VoteResult {
+func (rep *RepHTTPClient) Vote(ctx context.Context, guids []string, instance instance.Instance) ([]types.VoteResult, error) {
 	c := make(chan types.VoteResult)
 	for _, guid := range guids {
-		go rep.vote(guid, instance, c)
+		go rep.vote(ctx, guid, instance, c)
 	}
 
 	results := []types.VoteResult{}
@@ -162,74 +182,47 @@ func (rep *RepHTTPClient) Vote(guids []string, instance instance.Instance) []typ
 		results = append(results, <-c)
 	}
 
-	return results
+	return results, ctx.Err()
 }
 
-func (rep *RepHTTPClient) ReserveAndRecastVote(guid string, instance instance.Instance) (float64, error) {
-	rep.enter()
-	defer rep.exit()
-
-	body := new(bytes.Buffer)
-
-	err := json.NewEncoder(body).Encode(instance)
-	if err != nil {
-		return 0, err
-	}
-
-	resp, err := rep.client.Post(rep.endpoints[guid]+"/reserve_and_recast_vote", "application/json", body)
-	if err != nil {
-		return 0, err
-	}
-
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return 0, errors.New("failed")
-	}
-
+func (rep *RepHTTPClient) ReserveAndRecastVote(ctx context.Context, guid string, instance instance.Instance) (float64, error) {
 	var score float64
-	err = json.NewDecoder(resp.Body).Decode(&score)
-	if err != nil {
-		return 0, err
-	}
-
-	return score, nil
+	err := rep.requestWithRetries(ctx, guid, "/reserve_and_recast_vote", instance, &score)
+	return score, err
 }
 
-func (rep *RepHTTPClient) Release(guid string, instance instance.Instance) {
-	rep.enter()
-	defer rep.exit()
-
-	body := new(bytes.Buffer)
-
-	err := json.NewEncoder(body).Encode(instance)
-	if err != nil {
-		panic("failed to encode instance: " + err.Error())
-	}
-
-	resp, err := rep.client.Post(rep.endpoints[guid]+"/release", "application/json", body)
-	if err != nil {
-		return
-	}
+func (rep *RepHTTPClient) Release(ctx context.Context, guid string, instance instance.Instance) error {
+	return rep.requestWithRetries(ctx, guid, "/release", instance, nil)
+}
 
-	resp.Body.Close()
+func (rep *RepHTTPClient) Claim(ctx context.Context, guid string, instance instance.Instance) ([]int, error) {
+	var hostPorts []int
+	err := rep.requestWithRetries(ctx, guid, "/claim", instance, &hostPorts)
+	return hostPorts, err
 }
 
-func (rep *RepHTTPClient) Claim(guid string, instance instance.Instance) {
-	rep.enter()
-	defer rep.exit()
+func (rep *RepHTTPClient) Preempt(ctx context.Context, guid string, inst instance.Instance) ([]instance.Instance, error) {
+	var preempted []instance.Instance
+	err := rep.request(ctx, guid, "/preempt", inst, &preempted)
+	return preempted, err
+}
 
-	body := new(bytes.Buffer)
+func (rep *RepHTTPClient) VoteBatch(ctx context.Context, guid string, instances []instance.Instance) ([]types.VoteResult, error) {
+	var results []types.VoteResult
+	err := rep.request(ctx, guid, "/vote_batch", instances, &results)
+	return results, err
+}
 
-	err := json.NewEncoder(body).Encode(instance)
-	if err != nil {
-		panic("failed to encode instance: " + err.Error())
-	}
+func (rep *RepHTTPClient) Stop(ctx context.Context, guid string, instanceGuid string) error {
+	return rep.request(ctx, guid, "/stop", instanceGuid, nil)
+}
 
-	resp, err := rep.client.Post(rep.endpoints[guid]+"/claim", "application/json", body)
-	if err != nil {
-		return
-	}
+func (rep *RepHTTPClient) Drain(ctx context.Context, guid string) ([]instance.Instance, error) {
+	var instances []instance.Instance
+	err := rep.request(ctx, guid, "/drain", nil, &instances)
+	return instances, err
+}
 
-	resp.Body.Close()
+func (rep *RepHTTPClient) ReleaseEvacuated(ctx context.Context, guid string, instance instance.Instance) error {
+	return rep.request(ctx, guid, "/release_evacuated", instance, nil)
 }
@@ -7,6 +7,7 @@ import (
 
 	"github.com/onsi/auction/instance"
 	"github.com/onsi/auction/representative"
+	"github.com/onsi/auction/types"
 )
 
 func Start(httpAddr string, rep *representative.Representative) {
@@ -14,12 +15,43 @@ func Start(httpAddr string, rep *representative.Representative) {
 		json.NewEncoder(w).Encode(rep.TotalResources())
 	})
 
+	http.HandleFunc("/state", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(rep.State())
+	})
+
+	http.HandleFunc("/set_total_resources", func(w http.ResponseWriter, r *http.Request) {
+		var request types.ResizeRequest
+
+		err := json.NewDecoder(r.Body).Decode(&request)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		json.NewEncoder(w).Encode(rep.SetTotalResources(request.TotalResources, request.NominateEvictions))
+	})
+
+	http.HandleFunc("/capacity", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(rep.Capacity())
+	})
+
+	http.HandleFunc("/zone", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(rep.Zone())
+	})
+
+	http.HandleFunc("/reaped_reservations", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(rep.ReapedReservations())
+	})
+
 	http.HandleFunc("/instances", func(w http.ResponseWriter, r *http.Request) {
 		json.NewEncoder(w).Encode(rep.Instances())
 	})
 
 	http.HandleFunc("/reset", func(w http.ResponseWriter, r *http.Request) {
-		rep.Reset()
+		err := rep.Reset()
+		if err != nil {
+			writeError(w, err)
+		}
 	})
 
 	http.HandleFunc("/set_instances", func(w http.ResponseWriter, r *http.Request) {
@@ -31,7 +63,10 @@ func Start(httpAddr string, rep *representative.Representative) {
 			return
 		}
 
-		rep.SetInstances(instances)
+		err = rep.SetInstances(instances)
+		if err != nil {
+			writeError(w, err)
+		}
 	})
 
 	http.HandleFunc("/vote", func(w http.ResponseWriter, r *http.Request) {
@@ -43,13 +78,25 @@ func Start(httpAddr string, rep *representative.Representative) {
 			return
 		}
 
-		score, err := rep.Vote(inst)
+		vote, err := rep.Vote(inst)
 		if err != nil {
-			w.WriteHeader(http.StatusServiceUnavailable)
+			writeError(w, err)
 			return
 		}
 
-		json.NewEncoder(w).Encode(score)
+		json.NewEncoder(w).Encode(vote)
+	})
+
+	http.HandleFunc("/vote_batch", func(w http.ResponseWriter, r *http.Request) {
+		var instances []instance.Instance
+
+		err := json.NewDecoder(r.Body).Decode(&instances)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		json.NewEncoder(w).Encode(rep.VoteBatch(instances))
 	})
 
 	http.HandleFunc("/reserve_and_recast_vote", func(w http.ResponseWriter, r *http.Request) {
@@ -63,13 +110,31 @@ func Start(httpAddr string, rep *representative.Representative) {
 
 		score, err := rep.ReserveAndRecastVote(inst)
 		if err != nil {
-			w.WriteHeader(http.StatusServiceUnavailable)
+			writeError(w, err)
 			return
 		}
 
 		json.NewEncoder(w).Encode(score)
 	})
 
+	http.HandleFunc("/preempt", func(w http.ResponseWriter, r *http.Request) {
+		var inst instance.Instance
+
+		err := json.NewDecoder(r.Body).Decode(&inst)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		preempted, err := rep.Preempt(inst)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		json.NewEncoder(w).Encode(preempted)
+	})
+
 	http.HandleFunc("/release", func(w http.ResponseWriter, r *http.Request) {
 		var inst instance.Instance
 
@@ -79,7 +144,11 @@ func Start(httpAddr string, rep *representative.Representative) {
 			return
 		}
 
-		rep.Release(inst)
+		err = rep.Release(inst)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
 
 		w.WriteHeader(http.StatusOK)
 	})
@@ -93,7 +162,51 @@ func Start(httpAddr string, rep *representative.Representative) {
 			return
 		}
 
-		rep.Claim(inst)
+		hostPorts, err := rep.Claim(inst)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		json.NewEncoder(w).Encode(hostPorts)
+	})
+
+	http.HandleFunc("/stop", func(w http.ResponseWriter, r *http.Request) {
+		var instanceGuid string
+
+		err := json.NewDecoder(r.Body).Decode(&instanceGuid)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		err = rep.Stop(instanceGuid)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	})
+
+	http.HandleFunc("/drain", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(rep.Drain())
+	})
+
+	http.HandleFunc("/release_evacuated", func(w http.ResponseWriter, r *http.Request) {
+		var inst instance.Instance
+
+		err := json.NewDecoder(r.Body).Decode(&inst)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		err = rep.ReleaseEvacuated(inst)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
 
 		w.WriteHeader(http.StatusOK)
 	})
@@ -102,3 +215,18 @@ func Start(httpAddr string, rep *representative.Representative) {
 
 	panic(http.ListenAndServe(httpAddr, nil))
 }
+
+// writeError sends the error's message as the body so that clients can map it back to the sentinel error
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch err {
+	case types.InsufficientResources, types.IncompatibleStack, types.ConstraintMismatch, types.InvalidConstraint, types.Draining:
+		status = http.StatusServiceUnavailable
+	case types.UnknownReservation, types.UnknownInstance:
+		status = http.StatusNotFound
+	case types.AlreadyClaimed, types.NotDraining:
+		status = http.StatusConflict
+	}
+
+	http.Error(w, err.Error(), status)
+}
@@ -1,19 +1,115 @@
 package instance
 
-import "github.com/onsi/auction/util"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/onsi/auction/util"
+)
+
+type Resources struct {
+	MemoryMB   int
+	DiskMB     int
+	Containers int
+}
 
 type Instance struct {
-	AppGuid           string
-	InstanceGuid      string
-	RequiredResources int
-	Tentative         bool
+	AppGuid      string
+	InstanceGuid string
+	Resources    Resources
+	Stack        string
+	Constraint   string // label selector, e.g. "gpu=false,tier in (prod,staging)"
+	Priority     int    // higher priority instances may preempt lower priority ones
+	Ports        int    // number of host ports the instance needs
+	HostPorts    []int  // assigned by the rep that reserves the instance
+	Tentative    bool
 }
 
-func New(appGuid string, requiredResources int) Instance {
+func New(appGuid string, resources Resources) Instance {
 	return Instance{
-		AppGuid:           appGuid,
-		InstanceGuid:      util.NewGuid("INS"),
-		RequiredResources: requiredResources,
-		Tentative:         false,
+		AppGuid:      appGuid,
+		InstanceGuid: util.NewGuid("INS"),
+		Resources:    resources,
+		Tentative:    false,
 	}
 }
+
+// ParseResources parses resources of the form "memory=1024,disk=4096,containers=100"
+func ParseResources(s string) (Resources, error) {
+	resources := Resources{}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 {
+			return Resources{}, fmt.Errorf("invalid resource %q", pair)
+		}
+
+		value, err := strconv.Atoi(kv[1])
+		if err != nil {
+			return Resources{}, fmt.Errorf("invalid resource %q: %s", pair, err)
+		}
+
+		switch kv[0] {
+		case "memory":
+			resources.MemoryMB = value
+		case "disk":
+			resources.DiskMB = value
+		case "containers":
+			resources.Containers = value
+		default:
+			return Resources{}, fmt.Errorf("unknown resource %q", kv[0])
+		}
+	}
+
+	return resources, nil
+}
+
+func (r Resources) String() string {
+	return fmt.Sprintf("memory=%d,disk=%d,containers=%d", r.MemoryMB, r.DiskMB, r.Containers)
+}
+
+func (r Resources) Add(other Resources) Resources {
+	return Resources{
+		MemoryMB:   r.MemoryMB + other.MemoryMB,
+		DiskMB:     r.DiskMB + other.DiskMB,
+		Containers: r.Containers + other.Containers,
+	}
+}
+
+func (r Resources) Subtract(other Resources) Resources {
+	return Resources{
+		MemoryMB:   r.MemoryMB - other.MemoryMB,
+		DiskMB:     r.DiskMB - other.DiskMB,
+		Containers: r.Containers - other.Containers,
+	}
+}
+
+// FitsIn is true only if every dimension of r is within capacity
+func (r Resources) FitsIn(capacity Resources) bool {
+	return r.MemoryMB <= capacity.MemoryMB &&
+		r.DiskMB <= capacity.DiskMB &&
+		r.Containers <= capacity.Containers
+}
+
+// FractionOf is the mean utilization of total across all dimensions
+// (dimensions with no capacity are ignored)
+func (r Resources) FractionOf(total Resources) float64 {
+	fraction, dimensions := 0.0, 0
+	for _, pair := range [][2]int{
+		{r.MemoryMB, total.MemoryMB},
+		{r.DiskMB, total.DiskMB},
+		{r.Containers, total.Containers},
+	} {
+		if pair[1] == 0 {
+			continue
+		}
+		fraction += float64(pair[0]) / float64(pair[1])
+		dimensions++
+	}
+
+	if dimensions == 0 {
+		return 0
+	}
+
+	return fraction / float64(dimensions)
+}
@@ -1,11 +1,14 @@
 package ketchup_test
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"strings"
 
 	"github.com/cloudfoundry/yagnats"
 	"github.com/onsi/auction/auctioneer"
+	"github.com/onsi/auction/instance"
 	"github.com/onsi/auction/nats/repnatsclient"
 	"github.com/onsi/auction/types"
 	"github.com/onsi/auction/util"
@@ -20,12 +23,13 @@ var guids []string
 var natsAddrs []string
 
 var numReps int
-var repResources int
+var repResources instance.Resources
 
 var rules types.AuctionRules
 var timeout time.Duration
 
 var auctioneerMode string
+var algorithm string
 
 // plumbing
 var natsClient yagnats.NATSClient
@@ -34,11 +38,14 @@ var communicator types.AuctionCommunicator
 
 func init() {
 	flag.StringVar(&auctioneerMode, "auctioneerMode", "inprocess", "one of inprocess, remote")
+	flag.StringVar(&algorithm, "algorithm", auctioneer.DefaultAlgorithm, "the placement algorithm, one of "+strings.Join(auctioneer.AlgorithmNames(), ", "))
 
 	flag.IntVar(&(auctioneer.DefaultRules.MaxRounds), "maxRounds", auctioneer.DefaultRules.MaxRounds, "the maximum number of rounds per auction")
 	flag.IntVar(&(auctioneer.DefaultRules.MaxBiddingPool), "maxBiddingPool", auctioneer.DefaultRules.MaxBiddingPool, "the maximum number of participants in the pool")
 	flag.IntVar(&(auctioneer.DefaultRules.MaxConcurrent), "maxConcurrent", auctioneer.DefaultRules.MaxConcurrent, "the maximum number of concurrent auctions to run")
 	flag.BoolVar(&(auctioneer.DefaultRules.RepickEveryRound), "repickEveryRound", auctioneer.DefaultRules.RepickEveryRound, "whether to repick every round")
+	flag.Float64Var(&(auctioneer.DefaultRules.ZoneBalanceWeight), "zoneBalanceWeight", auctioneer.DefaultRules.ZoneBalanceWeight, "how strongly to avoid zones that already run more of an app")
+	flag.IntVar(&(auctioneer.DefaultRules.SecondRoundTopK), "secondRoundTopK", auctioneer.DefaultRules.SecondRoundTopK, "how many of the best first round bidders vote in the second round (0 for all)")
 }
 
 func TestAuction(t *testing.T) {
@@ -62,7 +69,7 @@ var _ = BeforeSuite(func() {
 	}
 
 	numReps = len(guids)
-	repResources = 100
+	repResources = instance.Resources{MemoryMB: 100 * 256, DiskMB: 100 * 1024, Containers: 100}
 
 	fmt.Printf("Running in %s auctioneerMode\n", auctioneerMode)
 
@@ -86,12 +93,18 @@ var _ = BeforeSuite(func() {
 	client = repnatsclient.New(natsClient, timeout)
 
 	if auctioneerMode == "inprocess" {
-		communicator = func(auctionRequest types.AuctionRequest) types.AuctionResult {
-			return auctioneer.Auction(client, auctionRequest)
+		communicator = func(ctx context.Context, auctionRequest types.AuctionRequest, observer types.AuctionObserver) types.AuctionResult {
+			if auctionRequest.Algorithm == "" {
+				auctionRequest.Algorithm = algorithm
+			}
+			return auctioneer.Auction(ctx, client, auctionRequest, observer)
 		}
 	} else if auctioneerMode == "remote" {
-		communicator = func(auctionRequest types.AuctionRequest) types.AuctionResult {
-			return auctioneer.RemoteAuction(natsClient, auctionRequest)
+		communicator = func(ctx context.Context, auctionRequest types.AuctionRequest, observer types.AuctionObserver) types.AuctionResult {
+			if auctionRequest.Algorithm == "" {
+				auctionRequest.Algorithm = algorithm
+			}
+			return auctioneer.RemoteAuction(ctx, natsClient, auctionRequest, observer)
 		}
 	} else {
 		panic("wat?")
@@ -100,7 +113,8 @@ var _ = BeforeSuite(func() {
 
 var _ = BeforeEach(func() {
 	for _, guid := range guids {
-		client.Reset(guid)
+		err := client.Reset(guid)
+		Ω(err).ShouldNot(HaveOccurred())
 	}
 
 	util.ResetGuids()
@@ -1,6 +1,8 @@
 package ketchup_test
 
 import (
+	"context"
+
 	"github.com/onsi/auction/auctioneer"
 	"github.com/onsi/auction/instance"
 	"github.com/onsi/auction/util"
@@ -15,10 +17,12 @@ var _ = Describe("Auction", func() {
 	var initialDistributions map[int][]instance.Instance
 	var numApps int
 
+	instanceResources := instance.Resources{MemoryMB: 256, DiskMB: 1024, Containers: 1}
+
 	generateUniqueInstances := func(numInstances int) []instance.Instance {
 		instances := []instance.Instance{}
 		for i := 0; i < numInstances; i++ {
-			instances = append(instances, instance.New(util.NewGuid("APP"), 1))
+			instances = append(instances, instance.New(util.NewGuid("APP"), instanceResources))
 		}
 		return instances
 	}
@@ -30,7 +34,7 @@ var _ = Describe("Auction", func() {
 	generateInstancesWithRandomColors := func(numInstances int) []instance.Instance {
 		instances := []instance.Instance{}
 		for i := 0; i < numInstances; i++ {
-			instances = append(instances, instance.New(randomColor(), 1))
+			instances = append(instances, instance.New(randomColor(), instanceResources))
 		}
 		return instances
 	}
@@ -38,7 +42,7 @@ var _ = Describe("Auction", func() {
 	generateInstancesForAppGuid := func(numInstances int, appGuid string) []instance.Instance {
 		instances := []instance.Instance{}
 		for i := 0; i < numInstances; i++ {
-			instances = append(instances, instance.New(appGuid, 1))
+			instances = append(instances, instance.New(appGuid, instanceResources))
 		}
 		return instances
 	}
@@ -58,7 +62,8 @@ var _ = Describe("Auction", func() {
 
 	JustBeforeEach(func() {
 		for index, instances := range initialDistributions {
-			client.SetInstances(guids[index], instances)
+			err := client.SetInstances(guids[index], instances)
+			Ω(err).ShouldNot(HaveOccurred())
 		}
 	})
 
@@ -70,7 +75,7 @@ var _ = Describe("Auction", func() {
 		It("should distribute evenly", func() {
 			instances := generateUniqueInstances(numApps)
 
-			results, duration := auctioneer.HoldAuctionsFor(client, instances, guids, rules, communicator)
+			results, duration := auctioneer.HoldAuctionsFor(context.Background(), client, instances, guids, rules, communicator)
 
 			visualization.PrintReport(client, results, guids, duration, rules)
 		})
@@ -89,7 +94,7 @@ var _ = Describe("Auction", func() {
 		It("should distribute evenly", func() {
 			instances := generateUniqueInstances(numApps)
 
-			results, duration := auctioneer.HoldAuctionsFor(client, instances, guids, rules, communicator)
+			results, duration := auctioneer.HoldAuctionsFor(context.Background(), client, instances, guids, rules, communicator)
 
 			visualization.PrintReport(client, results, guids, duration, rules)
 		})
@@ -113,7 +118,7 @@ var _ = Describe("Auction", func() {
 		It("should distribute evenly when watters does a demo", func() {
 			instances := generateInstancesForAppGuid(numDemoInstances, "red")
 
-			results, duration := auctioneer.HoldAuctionsFor(client, instances, guids[:numReps], rules, communicator)
+			results, duration := auctioneer.HoldAuctionsFor(context.Background(), client, instances, guids[:numReps], rules, communicator)
 
 			visualization.PrintReport(client, results, guids[:numReps], duration, rules)
 		})
@@ -134,7 +139,7 @@ var _ = Describe("Auction", func() {
 		It("should distribute evenly", func() {
 			instances := generateUniqueInstances(numDemoInstances)
 
-			results, duration := auctioneer.HoldAuctionsFor(client, instances, guids[:numReps], rules, communicator)
+			results, duration := auctioneer.HoldAuctionsFor(context.Background(), client, instances, guids[:numReps], rules, communicator)
 
 			visualization.PrintReport(client, results, guids[:numReps], duration, rules)
 		})
@@ -160,7 +165,7 @@ var _ = Describe("Auction", func() {
 
 			It("should distribute evenly", func() {
 				instances := generateNewColorInstances(newInstances)
-				results, duration := auctioneer.HoldAuctionsFor(client, instances, guids, rules, communicator)
+				results, duration := auctioneer.HoldAuctionsFor(context.Background(), client, instances, guids, rules, communicator)
 				visualization.PrintReport(client, results, guids, duration, rules)
 			})
 		})
@@ -180,7 +185,7 @@ var _ = Describe("Auction", func() {
 				instances := generateNewColorInstances(newInstances)
 				instances = append(instances, generateUniqueInstances(2000)...)
 
-				results, duration := auctioneer.HoldAuctionsFor(client, instances, guids, rules, communicator)
+				results, duration := auctioneer.HoldAuctionsFor(context.Background(), client, instances, guids, rules, communicator)
 				visualization.PrintReport(client, results, guids, duration, rules)
 			})
 		})
@@ -0,0 +1,176 @@
+package labels
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	Equals    = "="
+	NotEquals = "!="
+	In        = "in"
+	NotIn     = "notin"
+)
+
+// characters that are part of the syntax, and so can't appear in keys or values
+const reserved = "=!(),"
+
+type Requirement struct {
+	Key      string
+	Operator string
+	Values   []string
+}
+
+// Selector matches labels only if every requirement matches
+type Selector []Requirement
+
+// Parse parses constraint expressions such as "gpu=false,tier in (prod,staging),zone notin (z3)"
+// Keys that are absent satisfy != and notin requirements but never = or in requirements.
+func Parse(expression string) (Selector, error) {
+	selector := Selector{}
+	for _, clause := range splitClauses(expression) {
+		if clause == "" {
+			continue
+		}
+
+		requirement, err := parseRequirement(clause)
+		if err != nil {
+			return nil, err
+		}
+
+		selector = append(selector, requirement)
+	}
+
+	return selector, nil
+}
+
+// ParseLabels parses labels of the form "gpu=false,tier=prod"
+func ParseLabels(s string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid label %q", pair)
+		}
+
+		labels[kv[0]] = kv[1]
+	}
+
+	return labels, nil
+}
+
+func (selector Selector) Matches(labels map[string]string) bool {
+	for _, requirement := range selector {
+		if !requirement.Matches(labels) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (requirement Requirement) Matches(labels map[string]string) bool {
+	value, ok := labels[requirement.Key]
+
+	switch requirement.Operator {
+	case Equals, In:
+		return ok && contains(requirement.Values, value)
+	case NotEquals, NotIn:
+		return !ok || !contains(requirement.Values, value)
+	}
+
+	return false
+}
+
+// internals
+
+func splitClauses(expression string) []string {
+	clauses := []string{}
+	depth, start := 0, 0
+	for i, c := range expression {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				clauses = append(clauses, strings.TrimSpace(expression[start:i]))
+				start = i + 1
+			}
+		}
+	}
+
+	return append(clauses, strings.TrimSpace(expression[start:]))
+}
+
+// set-based clauses are recognized first, so that an = inside the parentheses
+// isn't mistaken for an equality
+func parseRequirement(clause string) (Requirement, error) {
+	fields := strings.Fields(clause)
+	if len(fields) >= 2 && (fields[1] == In || fields[1] == NotIn) {
+		return parseSetRequirement(clause, fields)
+	}
+
+	if i := strings.Index(clause, NotEquals); i >= 0 {
+		return newRequirement(clause, clause[:i], NotEquals, []string{clause[i+len(NotEquals):]})
+	}
+
+	if i := strings.Index(clause, Equals); i >= 0 {
+		value := strings.TrimPrefix(clause[i+len(Equals):], Equals) // allow ==
+		return newRequirement(clause, clause[:i], Equals, []string{value})
+	}
+
+	return Requirement{}, fmt.Errorf("invalid constraint %q", clause)
+}
+
+func parseSetRequirement(clause string, fields []string) (Requirement, error) {
+	if len(fields) < 3 {
+		return Requirement{}, fmt.Errorf("invalid constraint %q", clause)
+	}
+
+	set := strings.TrimSpace(strings.Join(fields[2:], " "))
+	if !strings.HasPrefix(set, "(") || !strings.HasSuffix(set, ")") {
+		return Requirement{}, fmt.Errorf("invalid constraint %q: values must be in parentheses", clause)
+	}
+
+	return newRequirement(clause, fields[0], fields[1], strings.Split(set[1:len(set)-1], ","))
+}
+
+func newRequirement(clause string, key string, operator string, values []string) (Requirement, error) {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return Requirement{}, fmt.Errorf("invalid constraint %q: missing key", clause)
+	}
+
+	if strings.ContainsAny(key, reserved) || len(strings.Fields(key)) > 1 {
+		return Requirement{}, fmt.Errorf("invalid constraint %q: invalid key %q", clause, key)
+	}
+
+	for i := range values {
+		values[i] = strings.TrimSpace(values[i])
+		if strings.ContainsAny(values[i], reserved) {
+			return Requirement{}, fmt.Errorf("invalid constraint %q: invalid value %q", clause, values[i])
+		}
+	}
+
+	return Requirement{
+		Key:      key,
+		Operator: operator,
+		Values:   values,
+	}, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -1,7 +1,7 @@
 package lossyrep
 
 import (
-	"errors"
+	"context"
 	"time"
 
 	"github.com/onsi/auction/instance"
@@ -15,6 +15,9 @@ var LatencyMax time.Duration
 var Timeout time.Duration
 var Flakiness = 1.0
 
+// MaxRetries is how many times reserves, claims and releases are retried after timing out
+var MaxRetries = 2
+
 type LossyRep struct {
 	reps      map[string]*representative.Representative
 	FlakyReps map[string]bool
@@ -42,23 +45,68 @@ func (rep *LossyRep) beSlowAndFlakey(guid string) bool {
 	return false
 }
 
-func (rep *LossyRep) TotalResources(guid string) int {
-	return rep.reps[guid].TotalResources()
+// roundTrip simulates the network around call: it fails with the context's error if the
+// context is done before the request is sent or the reply arrives, and with TimeoutError if
+// either is lost.  Once sent the request reaches the rep unless it is lost, so about half the
+// time a TimeoutError means the rep has acted and only the reply went missing.
+func (rep *LossyRep) roundTrip(ctx context.Context, guid string, call func()) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	lost := rep.beSlowAndFlakey(guid)
+	if !lost || util.Flake(0.5) {
+		call()
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if lost {
+		return types.TimeoutError
+	}
+
+	return nil
+}
+
+func (rep *LossyRep) TotalResources(guid string) (instance.Resources, error) {
+	return rep.reps[guid].TotalResources(), nil
+}
+
+func (rep *LossyRep) Capacity(guid string) (instance.Resources, error) {
+	return rep.reps[guid].Capacity(), nil
 }
 
-func (rep *LossyRep) Instances(guid string) []instance.Instance {
-	return rep.reps[guid].Instances()
+func (rep *LossyRep) State(guid string) (types.RepState, error) {
+	return rep.reps[guid].State(), nil
 }
 
-func (rep *LossyRep) SetInstances(guid string, instances []instance.Instance) {
-	rep.reps[guid].SetInstances(instances)
+func (rep *LossyRep) SetTotalResources(guid string, request types.ResizeRequest) (types.ResizeResult, error) {
+	return rep.reps[guid].SetTotalResources(request.TotalResources, request.NominateEvictions), nil
 }
 
-func (rep *LossyRep) Reset(guid string) {
-	rep.reps[guid].Reset()
+func (rep *LossyRep) Zone(guid string) (string, error) {
+	return rep.reps[guid].Zone(), nil
 }
 
-func (rep *LossyRep) vote(guid string, instance instance.Instance, c chan types.VoteResult) {
+func (rep *LossyRep) ReapedReservations(guid string) (int, error) {
+	return rep.reps[guid].ReapedReservations(), nil
+}
+
+func (rep *LossyRep) Instances(guid string) ([]instance.Instance, error) {
+	return rep.reps[guid].Instances(), nil
+}
+
+func (rep *LossyRep) SetInstances(guid string, instances []instance.Instance) error {
+	return rep.reps[guid].SetInstances(instances)
+}
+
+func (rep *LossyRep) Reset(guid string) error {
+	return rep.reps[guid].Reset()
+}
+
+func (rep *LossyRep) vote(ctx context.Context, guid string, instance instance.Instance, c chan types.VoteResult) {
 	result := types.VoteResult{
 		Rep: guid,
 	}
@@ -66,25 +114,27 @@ func (rep *LossyRep) vote(guid string, instance instance.Instance, c chan types.
 		c <- result
 	}()
 
-	if rep.beSlowAndFlakey(guid) {
-		result.Error = "timeout"
-		return
+	var vote types.VoteResult
+	var voteErr error
+	err := rep.roundTrip(ctx, guid, func() {
+		vote, voteErr = rep.reps[guid].Vote(instance)
+	})
+	if err == nil {
+		err = voteErr
 	}
-
-	score, err := rep.reps[guid].Vote(instance)
 	if err != nil {
 		result.Error = err.Error()
 		return
 	}
 
-	result.Score = score
+	result = vote
 	return
 }
 
-func (rep *LossyRep) Vote(representatives []string, instance instance.Instance) []types.VoteResult {
+func (rep *LossyRep) Vote(ctx context.Context, representatives []string, instance instance.Instance) ([]types.VoteResult, error) {
 	c := make(chan types.VoteResult)
 	for _, guid := range representatives {
-		go rep.vote(guid, instance, c)
+		go rep.vote(ctx, guid, instance, c)
 	}
 
 	results := []types.VoteResult{}
@@ -92,25 +142,114 @@ func (rep *LossyRep) Vote(representatives []string, instance instance.Instance)
 		results = append(results, <-c)
 	}
 
-	return results
+	return results, ctx.Err()
 }
 
-func (rep *LossyRep) ReserveAndRecastVote(guid string, instance instance.Instance) (float64, error) {
-	if rep.beSlowAndFlakey(guid) {
-		return 0, errors.New("timeout")
+func (rep *LossyRep) ReserveAndRecastVote(ctx context.Context, guid string, instance instance.Instance) (float64, error) {
+	var score float64
+	err := types.RetryTimeouts(MaxRetries, func() error {
+		var reserveErr error
+		err := rep.roundTrip(ctx, guid, func() {
+			score, reserveErr = rep.reps[guid].ReserveAndRecastVote(instance)
+		})
+		if err != nil {
+			return err
+		}
+		return reserveErr
+	})
+
+	return score, err
+}
+
+func (rep *LossyRep) Preempt(ctx context.Context, guid string, inst instance.Instance) ([]instance.Instance, error) {
+	var evicted []instance.Instance
+	var preemptErr error
+	err := rep.roundTrip(ctx, guid, func() {
+		evicted, preemptErr = rep.reps[guid].Preempt(inst)
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return rep.reps[guid].ReserveAndRecastVote(instance)
+	return evicted, preemptErr
 }
 
-func (rep *LossyRep) Release(guid string, instance instance.Instance) {
-	rep.beSlowAndFlakey(guid)
+func (rep *LossyRep) VoteBatch(ctx context.Context, guid string, instances []instance.Instance) ([]types.VoteResult, error) {
+	var votes []types.VoteResult
+	err := rep.roundTrip(ctx, guid, func() {
+		votes = rep.reps[guid].VoteBatch(instances)
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	rep.reps[guid].Release(instance)
+	return votes, nil
 }
 
-func (rep *LossyRep) Claim(guid string, instance instance.Instance) {
-	rep.beSlowAndFlakey(guid)
+func (rep *LossyRep) Stop(ctx context.Context, guid string, instanceGuid string) error {
+	var stopErr error
+	err := rep.roundTrip(ctx, guid, func() {
+		stopErr = rep.reps[guid].Stop(instanceGuid)
+	})
+	if err != nil {
+		return err
+	}
+
+	return stopErr
+}
+
+func (rep *LossyRep) Drain(ctx context.Context, guid string) ([]instance.Instance, error) {
+	var drained []instance.Instance
+	err := rep.roundTrip(ctx, guid, func() {
+		drained = rep.reps[guid].Drain()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return drained, nil
+}
+
+func (rep *LossyRep) ReleaseEvacuated(ctx context.Context, guid string, instance instance.Instance) error {
+	var releaseErr error
+	err := rep.roundTrip(ctx, guid, func() {
+		releaseErr = rep.reps[guid].ReleaseEvacuated(instance)
+	})
+	if err != nil {
+		return err
+	}
+
+	return releaseErr
+}
+
+func (rep *LossyRep) Release(ctx context.Context, guid string, instance instance.Instance) error {
+	return types.RetryTimeouts(MaxRetries, func() error {
+		var releaseErr error
+		err := rep.roundTrip(ctx, guid, func() {
+			releaseErr = rep.reps[guid].Release(instance)
+		})
+		if err != nil {
+			return err
+		}
+		return releaseErr
+	})
+}
+
+func (rep *LossyRep) Claim(ctx context.Context, guid string, instance instance.Instance) ([]int, error) {
+	var hostPorts []int
+	err := types.RetryTimeouts(MaxRetries, func() error {
+		var claimErr error
+		err := rep.roundTrip(ctx, guid, func() {
+			hostPorts, claimErr = rep.reps[guid].Claim(instance)
+		})
+		if err != nil {
+			return err
+		}
+		return claimErr
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	rep.reps[guid].Claim(instance)
+	return hostPorts, nil
 }
@@ -2,10 +2,10 @@ package repnatsclient
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"log"
+	"strings"
 	"sync"
 	"time"
 
@@ -15,8 +15,8 @@ import (
 	"github.com/onsi/auction/util"
 )
 
-var TimeoutError = errors.New("timeout")
-var RequestFailedError = errors.New("request failed")
+// MaxRetries is how many times reserves, claims and releases are retried after timing out
+var MaxRetries = 2
 
 type RepNatsClient struct {
 	client  yagnats.NATSClient
@@ -30,7 +30,13 @@ func New(client yagnats.NATSClient, timeout time.Duration) *RepNatsClient {
 	}
 }
 
-func (rep *RepNatsClient) publishWithTimeout(guid string, subject string, req interface{}, resp interface{}) (err error) {
+func (rep *RepNatsClient) publishWithRetries(ctx context.Context, guid string, subject string, req interface{}, resp interface{}) error {
+	return types.RetryTimeouts(MaxRetries, func() error {
+		return rep.publishWithTimeout(ctx, guid, subject, req, resp)
+	})
+}
+
+func (rep *RepNatsClient) publishWithTimeout(ctx context.Context, guid string, subject string, req interface{}, resp interface{}) (err error) {
 	replyTo := util.RandomGuid()
 	c := make(chan []byte, 1)
 
@@ -41,6 +47,10 @@ func (rep *RepNatsClient) publishWithTimeout(guid string, subject string, req in
 		return err
 	}
 
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
 	payload := []byte{}
 	if req != nil {
 		payload, err = json.Marshal(req)
@@ -53,8 +63,12 @@ func (rep *RepNatsClient) publishWithTimeout(guid string, subject string, req in
 
 	select {
 	case payload := <-c:
-		if string(payload) == "error" {
-			return RequestFailedError
+		if strings.HasPrefix(string(payload), "error") {
+			message := strings.TrimPrefix(strings.TrimPrefix(string(payload), "error"), ":")
+			if message == "" {
+				return types.RequestFailedError
+			}
+			return types.ErrorFromString(message)
 		}
 
 		if resp != nil {
@@ -65,45 +79,65 @@ func (rep *RepNatsClient) publishWithTimeout(guid string, subject string, req in
 
 	case <-time.After(rep.timeout):
 		// rep.client.Unsubscribe(sid)
-		return TimeoutError
+		return types.TimeoutError
+
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
-func (rep *RepNatsClient) TotalResources(guid string) int {
-	var totalResources int
-	err := rep.publishWithTimeout(guid, "total_resources", nil, &totalResources)
-	if err != nil {
-		panic(err)
-	}
+func (rep *RepNatsClient) TotalResources(guid string) (instance.Resources, error) {
+	var totalResources instance.Resources
+	err := rep.publishWithTimeout(context.Background(), guid, "total_resources", nil, &totalResources)
+	return totalResources, err
+}
 
-	return totalResources
+func (rep *RepNatsClient) Capacity(guid string) (instance.Resources, error) {
+	var capacity instance.Resources
+	err := rep.publishWithTimeout(context.Background(), guid, "capacity", nil, &capacity)
+	return capacity, err
 }
 
-func (rep *RepNatsClient) Instances(guid string) []instance.Instance {
-	var instances []instance.Instance
-	err := rep.publishWithTimeout(guid, "instances", nil, &instances)
-	if err != nil {
-		panic(err)
-	}
+func (rep *RepNatsClient) State(guid string) (types.RepState, error) {
+	var state types.RepState
+	err := rep.publishWithTimeout(context.Background(), guid, "state", nil, &state)
+	return state, err
+}
 
-	return instances
+func (rep *RepNatsClient) SetTotalResources(guid string, request types.ResizeRequest) (types.ResizeResult, error) {
+	var result types.ResizeResult
+	err := rep.publishWithTimeout(context.Background(), guid, "set_total_resources", request, &result)
+	return result, err
 }
 
-func (rep *RepNatsClient) Reset(guid string) {
-	err := rep.publishWithTimeout(guid, "reset", nil, nil)
-	if err != nil {
-		panic(err)
-	}
+func (rep *RepNatsClient) Zone(guid string) (string, error) {
+	var zone string
+	err := rep.publishWithTimeout(context.Background(), guid, "zone", nil, &zone)
+	return zone, err
 }
 
-func (rep *RepNatsClient) SetInstances(guid string, instances []instance.Instance) {
-	err := rep.publishWithTimeout(guid, "set_instances", instances, nil)
-	if err != nil {
-		panic(err)
-	}
+func (rep *RepNatsClient) ReapedReservations(guid string) (int, error) {
+	var reaped int
+	err := rep.publishWithTimeout(context.Background(), guid, "reaped_reservations", nil, &reaped)
+	return reaped, err
 }
 
-func (rep *RepNatsClient) Vote(guids []string, instance instance.Instance) []types.VoteResult {
+func (rep *RepNatsClient) Instances(guid string) ([]instance.Instance, error) {
+	var instances []instance.Instance
+	err := rep.publishWithTimeout(context.Background(), guid, "instances", nil, &instances)
+	return instances, err
+}
+
+func (rep *RepNatsClient) Reset(guid string) error {
+	return rep.publishWithTimeout(context.Background(), guid, "reset", nil, nil)
+}
+
+func (rep *RepNatsClient) SetInstances(guid string, instances []instance.Instance) error {
+	return rep.publishWithTimeout(context.Background(), guid, "set_instances", instances, nil)
+}
+
+// Vote reports reps that don't reply in time as having timed out (or been cancelled)
+func (rep *RepNatsClient) Vote(ctx context.Context, guids []string, instance instance.Instance) ([]types.VoteResult, error) {
 	replyTo := util.RandomGuid()
 
 	allReceived := new(sync.WaitGroup)
@@ -134,7 +168,7 @@ func (rep *RepNatsClient) Vote(guids []string, instance instance.Instance) []typ
 	})
 
 	if err != nil {
-		return []types.VoteResult{}
+		return []types.VoteResult{}, err
 	}
 
 	payload, _ := json.Marshal(instance)
@@ -158,39 +192,74 @@ func (rep *RepNatsClient) Vote(guids []string, instance instance.Instance) []typ
 	case <-done:
 	case <-time.After(rep.timeout):
 		println("TIMING OUT!!")
+	case <-ctx.Done():
 	}
 
 	results := []types.VoteResult{}
+	responded := map[string]bool{}
 
 	for {
 		select {
 		case res := <-responses:
 			results = append(results, res)
+			responded[res.Rep] = true
 		default:
-			return results
+			missing := types.TimeoutError
+			if ctx.Err() != nil {
+				missing = ctx.Err()
+			}
+			for _, guid := range guids {
+				if !responded[guid] {
+					results = append(results, types.VoteResult{
+						Rep:   guid,
+						Error: missing.Error(),
+					})
+				}
+			}
+			return results, ctx.Err()
 		}
 	}
-
-	return results
 }
 
-func (rep *RepNatsClient) ReserveAndRecastVote(guid string, instance instance.Instance) (float64, error) {
+func (rep *RepNatsClient) ReserveAndRecastVote(ctx context.Context, guid string, instance instance.Instance) (float64, error) {
 	var score float64
-	err := rep.publishWithTimeout(guid, "reserve_and_recast_vote", instance, &score)
+	err := rep.publishWithRetries(ctx, guid, "reserve_and_recast_vote", instance, &score)
 
 	return score, err
 }
 
-func (rep *RepNatsClient) Release(guid string, instance instance.Instance) {
-	err := rep.publishWithTimeout(guid, "release", instance, nil)
-	if err != nil {
-		log.Println("failed to release:", err)
-	}
+func (rep *RepNatsClient) Release(ctx context.Context, guid string, instance instance.Instance) error {
+	return rep.publishWithRetries(ctx, guid, "release", instance, nil)
 }
 
-func (rep *RepNatsClient) Claim(guid string, instance instance.Instance) {
-	err := rep.publishWithTimeout(guid, "claim", instance, nil)
-	if err != nil {
-		log.Println("failed to claim:", err)
-	}
+func (rep *RepNatsClient) Claim(ctx context.Context, guid string, instance instance.Instance) ([]int, error) {
+	var hostPorts []int
+	err := rep.publishWithRetries(ctx, guid, "claim", instance, &hostPorts)
+	return hostPorts, err
+}
+
+func (rep *RepNatsClient) Preempt(ctx context.Context, guid string, inst instance.Instance) ([]instance.Instance, error) {
+	var preempted []instance.Instance
+	err := rep.publishWithTimeout(ctx, guid, "preempt", inst, &preempted)
+	return preempted, err
+}
+
+func (rep *RepNatsClient) VoteBatch(ctx context.Context, guid string, instances []instance.Instance) ([]types.VoteResult, error) {
+	var results []types.VoteResult
+	err := rep.publishWithTimeout(ctx, guid, "vote_batch", instances, &results)
+	return results, err
+}
+
+func (rep *RepNatsClient) Stop(ctx context.Context, guid string, instanceGuid string) error {
+	return rep.publishWithTimeout(ctx, guid, "stop", instanceGuid, nil)
+}
+
+func (rep *RepNatsClient) Drain(ctx context.Context, guid string) ([]instance.Instance, error) {
+	var instances []instance.Instance
+	err := rep.publishWithTimeout(ctx, guid, "drain", nil, &instances)
+	return instances, err
+}
+
+func (rep *RepNatsClient) ReleaseEvacuated(ctx context.Context, guid string, instance instance.Instance) error {
+	return rep.publishWithTimeout(ctx, guid, "release_evacuated", instance, nil)
 }
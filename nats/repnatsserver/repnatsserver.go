@@ -14,6 +14,11 @@ import (
 var errorResponse = []byte("error")
 var successResponse = []byte("ok")
 
+// errorResponseFor carries the error's message so that clients can map it back to the sentinel error
+func errorResponseFor(err error) []byte {
+	return []byte("error:" + err.Error())
+}
+
 func Start(natsAddrs []string, rep *representative.Representative) {
 	client := yagnats.NewClient()
 
@@ -42,8 +47,47 @@ func Start(natsAddrs []string, rep *representative.Representative) {
 		client.Publish(msg.ReplyTo, jresources)
 	})
 
+	client.Subscribe(guid+".state", func(msg *yagnats.Message) {
+		jstate, _ := json.Marshal(rep.State())
+		client.Publish(msg.ReplyTo, jstate)
+	})
+
+	client.Subscribe(guid+".set_total_resources", func(msg *yagnats.Message) {
+		var request types.ResizeRequest
+
+		err := json.Unmarshal(msg.Payload, &request)
+		if err != nil {
+			log.Println(guid, "invalid set_total_resources request:", err)
+			client.Publish(msg.ReplyTo, errorResponse)
+			return
+		}
+
+		jresult, _ := json.Marshal(rep.SetTotalResources(request.TotalResources, request.NominateEvictions))
+		client.Publish(msg.ReplyTo, jresult)
+	})
+
+	client.Subscribe(guid+".capacity", func(msg *yagnats.Message) {
+		jresources, _ := json.Marshal(rep.Capacity())
+		client.Publish(msg.ReplyTo, jresources)
+	})
+
+	client.Subscribe(guid+".zone", func(msg *yagnats.Message) {
+		jzone, _ := json.Marshal(rep.Zone())
+		client.Publish(msg.ReplyTo, jzone)
+	})
+
+	client.Subscribe(guid+".reaped_reservations", func(msg *yagnats.Message) {
+		jreaped, _ := json.Marshal(rep.ReapedReservations())
+		client.Publish(msg.ReplyTo, jreaped)
+	})
+
 	client.Subscribe(guid+".reset", func(msg *yagnats.Message) {
-		rep.Reset()
+		err := rep.Reset()
+		if err != nil {
+			client.Publish(msg.ReplyTo, errorResponseFor(err))
+			return
+		}
+
 		client.Publish(msg.ReplyTo, successResponse)
 	})
 
@@ -53,9 +97,15 @@ func Start(natsAddrs []string, rep *representative.Representative) {
 		err := json.Unmarshal(msg.Payload, &instances)
 		if err != nil {
 			client.Publish(msg.ReplyTo, errorResponse)
+			return
+		}
+
+		err = rep.SetInstances(instances)
+		if err != nil {
+			client.Publish(msg.ReplyTo, errorResponseFor(err))
+			return
 		}
 
-		rep.SetInstances(instances)
 		client.Publish(msg.ReplyTo, successResponse)
 	})
 
@@ -67,11 +117,6 @@ func Start(natsAddrs []string, rep *representative.Representative) {
 	client.Subscribe(guid+".vote", func(msg *yagnats.Message) {
 		var inst instance.Instance
 
-		err := json.Unmarshal(msg.Payload, &inst)
-		if err != nil {
-			panic(err)
-		}
-
 		response := types.VoteResult{
 			Rep: guid,
 		}
@@ -81,14 +126,34 @@ func Start(natsAddrs []string, rep *representative.Representative) {
 			client.Publish(msg.ReplyTo, payload)
 		}()
 
-		score, err := rep.Vote(inst)
+		err := json.Unmarshal(msg.Payload, &inst)
+		if err != nil {
+			response.Error = types.RequestFailedError.Error()
+			return
+		}
+
+		vote, err := rep.Vote(inst)
 		if err != nil {
 			// log.Println(guid, "failed to vote:", err)
 			response.Error = err.Error()
 			return
 		}
 
-		response.Score = score
+		response = vote
+	})
+
+	client.Subscribe(guid+".vote_batch", func(msg *yagnats.Message) {
+		var instances []instance.Instance
+
+		err := json.Unmarshal(msg.Payload, &instances)
+		if err != nil {
+			log.Println(guid, "invalid vote_batch request:", err)
+			client.Publish(msg.ReplyTo, errorResponse)
+			return
+		}
+
+		jresults, _ := json.Marshal(rep.VoteBatch(instances))
+		client.Publish(msg.ReplyTo, jresults)
 	})
 
 	client.Subscribe(guid+".reserve_and_recast_vote", func(msg *yagnats.Message) {
@@ -107,13 +172,36 @@ func Start(natsAddrs []string, rep *representative.Representative) {
 
 		score, err := rep.ReserveAndRecastVote(inst)
 		if err != nil {
-			// log.Println(guid, "failed to reserve_and_recast_vote:", err)
+			responsePayload = errorResponseFor(err)
 			return
 		}
 
 		responsePayload, _ = json.Marshal(score)
 	})
 
+	client.Subscribe(guid+".preempt", func(msg *yagnats.Message) {
+		var inst instance.Instance
+
+		responsePayload := errorResponse
+		defer func() {
+			client.Publish(msg.ReplyTo, responsePayload)
+		}()
+
+		err := json.Unmarshal(msg.Payload, &inst)
+		if err != nil {
+			log.Println(guid, "invalid preempt request:", err)
+			return
+		}
+
+		preempted, err := rep.Preempt(inst)
+		if err != nil {
+			responsePayload = errorResponseFor(err)
+			return
+		}
+
+		responsePayload, _ = json.Marshal(preempted)
+	})
+
 	client.Subscribe(guid+".release", func(msg *yagnats.Message) {
 		var inst instance.Instance
 
@@ -124,11 +212,15 @@ func Start(natsAddrs []string, rep *representative.Representative) {
 
 		err := json.Unmarshal(msg.Payload, &inst)
 		if err != nil {
-			log.Println(guid, "invalid reserve_and_recast_vote request:", err)
+			log.Println(guid, "invalid release request:", err)
 			return
 		}
 
-		rep.Release(inst)
+		err = rep.Release(inst)
+		if err != nil {
+			responsePayload = errorResponseFor(err)
+			return
+		}
 
 		responsePayload = successResponse
 	})
@@ -143,11 +235,66 @@ func Start(natsAddrs []string, rep *representative.Representative) {
 
 		err := json.Unmarshal(msg.Payload, &inst)
 		if err != nil {
-			log.Println(guid, "invalid reserve_and_recast_vote request:", err)
+			log.Println(guid, "invalid claim request:", err)
+			return
+		}
+
+		hostPorts, err := rep.Claim(inst)
+		if err != nil {
+			responsePayload = errorResponseFor(err)
+			return
+		}
+
+		responsePayload, _ = json.Marshal(hostPorts)
+	})
+
+	client.Subscribe(guid+".stop", func(msg *yagnats.Message) {
+		var instanceGuid string
+
+		responsePayload := errorResponse
+		defer func() {
+			client.Publish(msg.ReplyTo, responsePayload)
+		}()
+
+		err := json.Unmarshal(msg.Payload, &instanceGuid)
+		if err != nil {
+			log.Println(guid, "invalid stop request:", err)
+			return
+		}
+
+		err = rep.Stop(instanceGuid)
+		if err != nil {
+			responsePayload = errorResponseFor(err)
+			return
+		}
+
+		responsePayload = successResponse
+	})
+
+	client.Subscribe(guid+".drain", func(msg *yagnats.Message) {
+		jinstances, _ := json.Marshal(rep.Drain())
+		client.Publish(msg.ReplyTo, jinstances)
+	})
+
+	client.Subscribe(guid+".release_evacuated", func(msg *yagnats.Message) {
+		var inst instance.Instance
+
+		responsePayload := errorResponse
+		defer func() {
+			client.Publish(msg.ReplyTo, responsePayload)
+		}()
+
+		err := json.Unmarshal(msg.Payload, &inst)
+		if err != nil {
+			log.Println(guid, "invalid release_evacuated request:", err)
 			return
 		}
 
-		rep.Claim(inst)
+		err = rep.ReleaseEvacuated(inst)
+		if err != nil {
+			responsePayload = errorResponseFor(err)
+			return
+		}
 
 		responsePayload = successResponse
 	})
@@ -3,16 +3,29 @@ package main
 import (
 	"flag"
 	"strings"
+	"time"
 
 	"github.com/onsi/auction/http/rephttpserver"
+	"github.com/onsi/auction/instance"
+	"github.com/onsi/auction/labels"
 	"github.com/onsi/auction/nats/repnatsserver"
 	"github.com/onsi/auction/representative"
+	"github.com/onsi/auction/scoring"
 )
 
-var resources = flag.Int("resources", 100, "total available resources")
+var resources = flag.String("resources", "memory=100,disk=100,containers=100", "total available resources")
+var overcommit = flag.String("overcommit", "", "per-resource overcommit factors, e.g. memory=1.5,disk=1,containers=1")
+var ports = flag.String("ports", "", "range of host ports to hand out to instances, e.g. 61000-61999")
+var stacks = flag.String("stacks", "", "comma-separated stacks the rep supports")
+var zone = flag.String("zone", "", "availability zone the rep runs in")
+var repLabels = flag.String("labels", "", "comma-separated key=value labels matched against instance constraints")
+var reservationTTL = flag.Duration("reservationTTL", 30*time.Second, "how long tentative reservations survive before they are reaped (0 to never reap)")
+var dataDir = flag.String("dataDir", "", "directory to persist instances and reservations in (empty to keep them in memory only)")
 var httpAddr = flag.String("httpAddr", "", "host:port")
 var guid = flag.String("guid", "", "guid")
 var natsAddrs = flag.String("natsAddrs", "", "nats server addresses")
+var scoringStrategy = flag.String("scoring", "default", "scoring strategy, one of "+strings.Join(scoring.StrategyNames(), ", "))
+var scoringConfig = flag.String("scoringConfig", "", "path to a JSON file of weighted scoring terms (overrides -scoring)")
 
 func main() {
 	flag.Parse()
@@ -25,7 +38,54 @@ func main() {
 		panic("need either nats or http addr (or both)")
 	}
 
-	rep := representative.New(*guid, *resources)
+	totalResources, err := instance.ParseResources(*resources)
+	if err != nil {
+		panic(err)
+	}
+
+	overcommitFactors, err := representative.ParseOvercommit(*overcommit)
+	if err != nil {
+		panic(err)
+	}
+
+	portRange, err := representative.ParsePortRange(*ports)
+	if err != nil {
+		panic(err)
+	}
+
+	var scorer scoring.Scorer
+	if *scoringConfig != "" {
+		scorer, err = scoring.LoadComposite(*scoringConfig)
+	} else {
+		scorer, err = scoring.Lookup(*scoringStrategy)
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	config := representative.Config{
+		TotalResources: totalResources,
+		Overcommit:     overcommitFactors,
+		Ports:          portRange,
+		Zone:           *zone,
+		Scorer:         scorer,
+		ReservationTTL: *reservationTTL,
+		DataDir:        *dataDir,
+	}
+
+	config.Labels, err = labels.ParseLabels(*repLabels)
+	if err != nil {
+		panic(err)
+	}
+
+	if *stacks != "" {
+		config.Stacks = strings.Split(*stacks, ",")
+	}
+
+	rep, err := representative.New(*guid, config)
+	if err != nil {
+		panic(err)
+	}
 
 	if *natsAddrs != "" {
 		go repnatsserver.Start(strings.Split(*natsAddrs, ","), rep)
@@ -0,0 +1,187 @@
+package representative
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/onsi/auction/instance"
+)
+
+const snapshotFile = "snapshot.json"
+const journalFile = "journal.log"
+
+// the journal is folded into a fresh snapshot once it holds this many entries
+const compactAfter = 1000
+
+// journal persists a rep's instances and reservations as a snapshot plus an append-only
+// log of the changes made since the snapshot was taken.  Changes are written (and synced)
+// before they are applied in memory, and replaying a change twice is harmless, so a crash
+// at any point recovers to a state the rep actually agreed to.
+type journal struct {
+	dir     string
+	log     *os.File
+	entries int
+}
+
+type snapshot struct {
+	Instances    []instance.Instance  `json:"instances"`
+	Reservations map[string]time.Time `json:"reservations"`
+}
+
+type journalEntry struct {
+	Op           string             `json:"op"` // put or remove
+	Instance     *instance.Instance `json:"instance,omitempty"`
+	InstanceGuid string             `json:"instance_guid,omitempty"`
+	Expiry       *time.Time         `json:"expiry,omitempty"`
+}
+
+// openJournal recovers the state persisted in dir.  The journal can't be appended to
+// until it is compacted into a fresh snapshot.
+func openJournal(dir string) (*journal, snapshot, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, snapshot{}, err
+	}
+
+	state, err := recoverState(dir)
+	if err != nil {
+		return nil, snapshot{}, err
+	}
+
+	return &journal{dir: dir}, state, nil
+}
+
+func (j *journal) put(instance instance.Instance, expiry time.Time) error {
+	entry := journalEntry{Op: "put", Instance: &instance}
+	if !expiry.IsZero() {
+		entry.Expiry = &expiry
+	}
+	return j.append(entry)
+}
+
+func (j *journal) remove(instanceGuid string) error {
+	return j.append(journalEntry{Op: "remove", InstanceGuid: instanceGuid})
+}
+
+func (j *journal) needsCompaction() bool {
+	return j.entries >= compactAfter
+}
+
+// compact atomically replaces the snapshot with state and starts an empty log
+func (j *journal) compact(state snapshot) error {
+	tmpPath := filepath.Join(j.dir, snapshotFile+".tmp")
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	err = json.NewEncoder(file).Encode(state)
+	if err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmpPath, filepath.Join(j.dir, snapshotFile))
+	if err != nil {
+		return err
+	}
+
+	log, err := os.OpenFile(filepath.Join(j.dir, journalFile), os.O_CREATE|os.O_TRUNC|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err //keep appending to the old log, replaying it over the new snapshot is harmless
+	}
+
+	if j.log != nil {
+		j.log.Close()
+	}
+
+	j.log = log
+	j.entries = 0
+	return nil
+}
+
+func (j *journal) append(entry journalEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	_, err = j.log.Write(append(line, '\n'))
+	if err != nil {
+		return err
+	}
+
+	j.entries++
+	return j.log.Sync()
+}
+
+func recoverState(dir string) (snapshot, error) {
+	state := snapshot{Reservations: map[string]time.Time{}}
+
+	file, err := os.Open(filepath.Join(dir, snapshotFile))
+	if err == nil {
+		err = json.NewDecoder(file).Decode(&state)
+		file.Close()
+		if err != nil {
+			return snapshot{}, err
+		}
+	} else if !os.IsNotExist(err) {
+		return snapshot{}, err
+	}
+
+	if state.Reservations == nil {
+		state.Reservations = map[string]time.Time{}
+	}
+
+	instances := map[string]instance.Instance{}
+	for _, instance := range state.Instances {
+		instances[instance.InstanceGuid] = instance
+	}
+
+	file, err = os.Open(filepath.Join(dir, journalFile))
+	if err == nil {
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(nil, 1024*1024)
+		for scanner.Scan() {
+			var entry journalEntry
+			if json.Unmarshal(scanner.Bytes(), &entry) != nil {
+				break //a torn write from a crash can only be the last entry
+			}
+
+			switch entry.Op {
+			case "put":
+				if entry.Instance == nil {
+					continue
+				}
+				instances[entry.Instance.InstanceGuid] = *entry.Instance
+				delete(state.Reservations, entry.Instance.InstanceGuid)
+				if entry.Expiry != nil {
+					state.Reservations[entry.Instance.InstanceGuid] = *entry.Expiry
+				}
+			case "remove":
+				delete(instances, entry.InstanceGuid)
+				delete(state.Reservations, entry.InstanceGuid)
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return snapshot{}, err
+		}
+	} else if !os.IsNotExist(err) {
+		return snapshot{}, err
+	}
+
+	state.Instances = []instance.Instance{}
+	for _, instance := range instances {
+		state.Instances = append(state.Instances, instance)
+	}
+
+	return state, nil
+}
@@ -0,0 +1,77 @@
+package representative
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/onsi/auction/instance"
+)
+
+// Overcommit scales a rep's capacity per dimension: a factor of 1.5 on memory lets the
+// rep place 50% more memory than it really has.  Factors of 0 (or 1) mean no overcommit.
+type Overcommit struct {
+	Memory     float64
+	Disk       float64
+	Containers float64
+}
+
+// ParseOvercommit parses factors of the form "memory=1.5,disk=1,containers=2"
+func ParseOvercommit(s string) (Overcommit, error) {
+	overcommit := Overcommit{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return Overcommit{}, fmt.Errorf("invalid overcommit %q", pair)
+		}
+
+		value, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil {
+			return Overcommit{}, fmt.Errorf("invalid overcommit %q: %s", pair, err)
+		}
+
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return Overcommit{}, fmt.Errorf("invalid overcommit %q: factors must be finite", pair)
+		}
+
+		if value != 0 && value < 1 {
+			return Overcommit{}, fmt.Errorf("invalid overcommit %q: factors must be at least 1", pair)
+		}
+
+		switch kv[0] {
+		case "memory":
+			overcommit.Memory = value
+		case "disk":
+			overcommit.Disk = value
+		case "containers":
+			overcommit.Containers = value
+		default:
+			return Overcommit{}, fmt.Errorf("unknown resource %q", kv[0])
+		}
+	}
+
+	return overcommit, nil
+}
+
+// Apply returns the overcommitted capacity of total
+func (overcommit Overcommit) Apply(total instance.Resources) instance.Resources {
+	return instance.Resources{
+		MemoryMB:   scale(total.MemoryMB, overcommit.Memory),
+		DiskMB:     scale(total.DiskMB, overcommit.Disk),
+		Containers: scale(total.Containers, overcommit.Containers),
+	}
+}
+
+func scale(value int, factor float64) int {
+	if factor == 0 {
+		return value
+	}
+
+	return int(math.Floor(float64(value) * factor))
+}
@@ -0,0 +1,32 @@
+package representative_test
+
+import (
+	"github.com/onsi/auction/representative"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ParseOvercommit", func() {
+	It("should parse a factor per resource", func() {
+		overcommit, err := representative.ParseOvercommit("memory=1.5, disk=1,containers=2")
+		Ω(err).ShouldNot(HaveOccurred())
+		Ω(overcommit).Should(Equal(representative.Overcommit{Memory: 1.5, Disk: 1, Containers: 2}))
+	})
+
+	It("should reject factors below 1", func() {
+		_, err := representative.ParseOvercommit("memory=0.5")
+		Ω(err).Should(HaveOccurred())
+	})
+
+	It("should reject factors that are not finite", func() {
+		for _, factor := range []string{"NaN", "Inf", "+Inf", "-Inf"} {
+			_, err := representative.ParseOvercommit("memory=" + factor)
+			Ω(err).Should(HaveOccurred(), factor)
+		}
+	})
+
+	It("should reject unknown resources", func() {
+		_, err := representative.ParseOvercommit("cpu=2")
+		Ω(err).Should(HaveOccurred())
+	})
+})
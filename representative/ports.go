@@ -0,0 +1,77 @@
+package representative
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/onsi/auction/instance"
+)
+
+// PortRange is the inclusive range of host ports a rep hands out to instances.
+// The zero PortRange has no ports, so instances that need ports never fit.
+type PortRange struct {
+	Min int
+	Max int
+}
+
+// ParsePortRange parses ranges of the form "61000-61999"
+func ParsePortRange(s string) (PortRange, error) {
+	if s == "" {
+		return PortRange{}, nil
+	}
+
+	bounds := strings.SplitN(s, "-", 2)
+	if len(bounds) != 2 {
+		return PortRange{}, fmt.Errorf("invalid port range %q", s)
+	}
+
+	min, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+	if err != nil {
+		return PortRange{}, fmt.Errorf("invalid port range %q: %s", s, err)
+	}
+
+	max, err := strconv.Atoi(strings.TrimSpace(bounds[1]))
+	if err != nil {
+		return PortRange{}, fmt.Errorf("invalid port range %q: %s", s, err)
+	}
+
+	if min <= 0 || max > 65535 || max < min {
+		return PortRange{}, fmt.Errorf("invalid port range %q", s)
+	}
+
+	return PortRange{Min: min, Max: max}, nil
+}
+
+func (r PortRange) String() string {
+	if r.Size() == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d-%d", r.Min, r.Max)
+}
+
+func (r PortRange) Size() int {
+	if r.Min <= 0 || r.Max < r.Min {
+		return 0
+	}
+	return r.Max - r.Min + 1
+}
+
+// assign gives the instance as many unallocated ports as it needs, lowest first.
+// It does not mark them allocated and assumes there are enough to go around.
+func (r PortRange) assign(inst instance.Instance, allocated map[int]bool) instance.Instance {
+	if inst.Ports == 0 {
+		inst.HostPorts = nil
+		return inst
+	}
+
+	hostPorts := []int{}
+	for port := r.Min; r.Size() > 0 && port <= r.Max && len(hostPorts) < inst.Ports; port++ {
+		if !allocated[port] {
+			hostPorts = append(hostPorts, port)
+		}
+	}
+
+	inst.HostPorts = hostPorts
+	return inst
+}
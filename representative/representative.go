@@ -1,54 +1,262 @@
 package representative
 
 import (
-	"errors"
+	"log"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/onsi/auction/instance"
+	"github.com/onsi/auction/labels"
+	"github.com/onsi/auction/scoring"
+	"github.com/onsi/auction/types"
 )
 
-var InsufficientResources = errors.New("insufficient resources for instance")
+type Config struct {
+	TotalResources instance.Resources
+
+	// Overcommit raises the capacity used for placement above TotalResources;
+	// scores are still computed against TotalResources
+	Overcommit Overcommit
+
+	// Stacks the rep can run; instances without a stack can run anywhere
+	Stacks []string
+
+	// Zone the rep lives in, reported with every vote
+	Zone string
+
+	// Labels are matched against instance constraints
+	Labels map[string]string
+
+	// Scorer defaults to scoring.Default
+	Scorer scoring.Scorer
+
+	// ReservationTTL is how long a tentative reservation survives without being
+	// claimed or released before it is reaped; zero means reservations never expire
+	ReservationTTL time.Duration
+
+	// Ports the rep hands out to instances that need host ports
+	Ports PortRange
+
+	// DataDir, if set, is where the rep persists its instances and reservations so
+	// that a restarted rep recovers them instead of coming back empty
+	DataDir string
+}
+
+// reservations recovered by a rep that does not expire reservations get this long to be claimed
+const recoveredReservationTTL = 30 * time.Second
 
 type Representative struct {
 	guid           string
 	lock           *sync.Mutex
 	instances      map[string]instance.Instance
-	totalResources int
+	totals         totals // kept up to date by put, remove and replace
+	generation     uint64
+	totalResources instance.Resources
+	capacity       instance.Resources
+	overcommit     Overcommit
+	portRange      PortRange
+	stacks         map[string]bool
+	zone           string
+	labels         map[string]string
+	scorer         scoring.Scorer
+	reservationTTL time.Duration
+	reservations   map[string]time.Time // instance guid => expiry
+	reaped         int
+	draining       bool
+	journal        *journal
+	done           chan struct{} // closed by Close to stop the reaper
 }
 
-func New(guid string, totalResources int) *Representative {
-	return &Representative{
+func New(guid string, config Config) (*Representative, error) {
+	stacks := map[string]bool{}
+	for _, stack := range config.Stacks {
+		stacks[stack] = true
+	}
+
+	scorer := config.Scorer
+	if scorer == nil {
+		scorer = scoring.Default
+	}
+
+	rep := &Representative{
 		guid:           guid,
-		totalResources: totalResources,
+		totalResources: config.TotalResources,
+		capacity:       config.Overcommit.Apply(config.TotalResources),
+		overcommit:     config.Overcommit,
+		portRange:      config.Ports,
+		stacks:         stacks,
+		zone:           config.Zone,
+		labels:         config.Labels,
+		scorer:         scorer,
+		reservationTTL: config.ReservationTTL,
+
+		lock:         &sync.Mutex{},
+		instances:    map[string]instance.Instance{},
+		totals:       newTotals(),
+		reservations: map[string]time.Time{},
+		done:         make(chan struct{}),
+	}
+
+	if config.DataDir != "" {
+		journal, state, err := openJournal(config.DataDir)
+		if err != nil {
+			return nil, err
+		}
+
+		rep.journal = journal
+		rep.recover(state)
+
+		//persist the expiries recover handed out
+		err = journal.compact(snapshotOf(rep.instances, rep.reservations))
+		if err != nil {
+			return nil, err
+		}
+	}
 
-		lock:      &sync.Mutex{},
-		instances: map[string]instance.Instance{},
+	if rep.reservationTTL > 0 || len(rep.reservations) > 0 {
+		go rep.reapForever()
 	}
+
+	return rep, nil
 }
 
 func (rep *Representative) Guid() string {
 	return rep.guid
 }
 
-func (rep *Representative) TotalResources() int {
+func (rep *Representative) Zone() string {
+	return rep.zone
+}
+
+func (rep *Representative) TotalResources() instance.Resources {
+	rep.lock.Lock()
+	defer rep.lock.Unlock()
 	return rep.totalResources
 }
 
-func (rep *Representative) Reset() {
+// Capacity is the (possibly overcommitted) amount of resources the rep will place
+func (rep *Representative) Capacity() instance.Resources {
+	rep.lock.Lock()
+	defer rep.lock.Unlock()
+	return rep.capacity
+}
+
+// SetTotalResources resizes a live rep.  Shrinking it below what it already runs evicts
+// nothing: the rep reports the overage and stops accepting instances until enough of them
+// go away.  With nominateEvictions it also suggests instances whose removal covers the overage.
+func (rep *Representative) SetTotalResources(total instance.Resources, nominateEvictions bool) types.ResizeResult {
+	rep.lock.Lock()
+	defer rep.lock.Unlock()
+
+	rep.totalResources = total
+	rep.capacity = rep.overcommit.Apply(total)
+	rep.generation++
+
+	overage := clip(rep.totals.used.Subtract(rep.capacity))
+	result := types.ResizeResult{
+		Capacity:      rep.capacity,
+		OverCommitted: overage != instance.Resources{},
+		Overage:       overage,
+	}
+
+	if result.OverCommitted && nominateEvictions {
+		result.EvictionCandidates = rep.evictionCandidates(overage)
+	}
+
+	return result
+}
+
+func (rep *Representative) Stacks() []string {
+	stacks := []string{}
+	for stack := range rep.stacks {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+	return stacks
+}
+
+func (rep *Representative) Reset() error {
 	rep.lock.Lock()
 	defer rep.lock.Unlock()
-	rep.instances = map[string]instance.Instance{}
+
+	err := rep.replace(map[string]instance.Instance{}, map[string]time.Time{})
+	if err != nil {
+		return err
+	}
+
+	rep.draining = false
+	return nil
 }
 
-func (rep *Representative) SetInstances(instances []instance.Instance) {
+func (rep *Representative) SetInstances(instances []instance.Instance) error {
 	rep.lock.Lock()
 	defer rep.lock.Unlock()
+
 	instancesMap := map[string]instance.Instance{}
+	reservations := map[string]time.Time{}
 	for _, instance := range instances {
 		instancesMap[instance.InstanceGuid] = instance
+		if instance.Tentative && rep.reservationTTL > 0 {
+			reservations[instance.InstanceGuid] = time.Now().Add(rep.reservationTTL)
+		}
+	}
+
+	return rep.replace(instancesMap, reservations)
+}
+
+// Reap deletes tentative reservations that have outlived the reservation TTL
+// and returns how many it deleted
+func (rep *Representative) Reap() int {
+	rep.lock.Lock()
+	defer rep.lock.Unlock()
+
+	now := time.Now()
+	reaped := 0
+	for guid, expiry := range rep.reservations {
+		if now.After(expiry) {
+			if rep.remove(guid) != nil {
+				break //try again next time around
+			}
+			reaped++
+		}
 	}
 
-	rep.instances = instancesMap
+	rep.reaped += reaped
+	return reaped
+}
+
+// ReapedReservations is the number of reservations reaped over the rep's lifetime
+func (rep *Representative) ReapedReservations() int {
+	rep.lock.Lock()
+	defer rep.lock.Unlock()
+	return rep.reaped
+}
+
+func (rep *Representative) State() types.RepState {
+	rep.lock.Lock()
+	defer rep.lock.Unlock()
+
+	appInstances := map[string]int{}
+	for appGuid, count := range rep.totals.appCounts {
+		appInstances[appGuid] = count
+	}
+
+	return types.RepState{
+		Rep:                rep.guid,
+		Zone:               rep.zone,
+		TotalResources:     rep.totalResources,
+		Capacity:           rep.capacity,
+		UsedResources:      rep.totals.used,
+		ReservedResources:  rep.totals.reserved,
+		FreePorts:          rep.freePorts(&rep.totals),
+		FreeResources:      clip(rep.capacity.Subtract(rep.totals.used)),
+		NumInstances:       len(rep.instances),
+		AppInstances:       appInstances,
+		Generation:         rep.generation,
+		Draining:           rep.draining,
+		ReapedReservations: rep.reaped,
+	}
 }
 
 func (rep *Representative) Instances() []instance.Instance {
@@ -62,84 +270,629 @@ func (rep *Representative) Instances() []instance.Instance {
 	return result
 }
 
-func (rep *Representative) Vote(instance instance.Instance) (float64, error) {
+// Stop removes an instance (claimed or not), freeing its resources
+func (rep *Representative) Stop(instanceGuid string) error {
 	rep.lock.Lock()
 	defer rep.lock.Unlock()
 
-	if !rep.hasRoomFor(instance) {
-		return 0, InsufficientResources
+	if _, ok := rep.instances[instanceGuid]; !ok {
+		return types.UnknownInstance
 	}
-	return rep.score(instance), nil
+
+	return rep.remove(instanceGuid)
 }
 
+// Drain stops the rep from accepting new work (including pending claims) and
+// returns the instances it still hosts, which should be evacuated elsewhere
+func (rep *Representative) Drain() []instance.Instance {
+	rep.lock.Lock()
+	defer rep.lock.Unlock()
+
+	if !rep.draining {
+		rep.draining = true
+		rep.generation++
+	}
+
+	result := []instance.Instance{}
+	for _, instance := range rep.instances {
+		if !instance.Tentative {
+			result = append(result, instance)
+		}
+	}
+	return result
+}
+
+func (rep *Representative) Draining() bool {
+	rep.lock.Lock()
+	defer rep.lock.Unlock()
+	return rep.draining
+}
+
+// ReleaseEvacuated removes an instance from a draining rep once it is running elsewhere
+func (rep *Representative) ReleaseEvacuated(instance instance.Instance) error {
+	rep.lock.Lock()
+	defer rep.lock.Unlock()
+
+	if !rep.draining {
+		return types.NotDraining
+	}
+
+	if _, ok := rep.instances[instance.InstanceGuid]; !ok {
+		return types.UnknownInstance
+	}
+
+	return rep.remove(instance.InstanceGuid)
+}
+
+// Vote also reports the rep's zone and how many instances of the app it runs
+// so that the auctioneer can balance apps across zones
+func (rep *Representative) Vote(instance instance.Instance) (types.VoteResult, error) {
+	rep.lock.Lock()
+	defer rep.lock.Unlock()
+
+	err := rep.canRun(instance)
+	if err != nil {
+		return types.VoteResult{}, err
+	}
+
+	if !rep.hasRoomFor(&rep.totals, instance) {
+		return types.VoteResult{}, types.InsufficientResources
+	}
+
+	return types.VoteResult{
+		Rep:          rep.guid,
+		Score:        rep.score(&rep.totals, instance),
+		Zone:         rep.zone,
+		AppInstances: rep.totals.appCounts[instance.AppGuid],
+	}, nil
+}
+
+// VoteBatch votes on each instance as if the instances before it had been placed
+// on the rep, without reserving anything.  Instances the rep cannot take get a vote
+// with an Error and do not count against later ones.
+func (rep *Representative) VoteBatch(instances []instance.Instance) []types.VoteResult {
+	rep.lock.Lock()
+	defer rep.lock.Unlock()
+
+	//the placements are simulated on a copy of the totals, the rep itself is untouched
+	totals := rep.totals.clone()
+	placed := map[string]bool{}
+
+	results := []types.VoteResult{}
+	for _, instance := range instances {
+		result := types.VoteResult{Rep: rep.guid}
+
+		err := rep.canRun(instance)
+		if err == nil && !rep.hasRoomFor(&totals, instance) {
+			err = types.InsufficientResources
+		}
+
+		if err != nil {
+			result.Error = err.Error()
+			results = append(results, result)
+			continue
+		}
+
+		result.Score = rep.score(&totals, instance)
+		result.Zone = rep.zone
+		result.AppInstances = totals.appCounts[instance.AppGuid]
+		results = append(results, result)
+
+		if _, ok := rep.instances[instance.InstanceGuid]; !ok && !placed[instance.InstanceGuid] {
+			totals.count(rep.portRange.assign(instance, totals.allocatedPorts), 1)
+			placed[instance.InstanceGuid] = true
+		}
+	}
+
+	return results
+}
+
+// Reserve, Claim and Release are idempotent so that clients can retry them when a
+// reply is lost:
+//   - reserving an instance that is already reserved refreshes the reservation (and recasts the same vote)
+//   - claiming an instance that is already claimed succeeds
+//   - releasing an instance the rep doesn't have succeeds
+// Releasing a claimed instance still fails: it is running and only Stop gets rid of it.
+
 func (rep *Representative) ReserveAndRecastVote(instance instance.Instance) (float64, error) {
 	rep.lock.Lock()
 	defer rep.lock.Unlock()
 
-	if !rep.hasRoomFor(instance) {
-		return 0, InsufficientResources
+	err := rep.canRun(instance)
+	if err != nil {
+		return 0, err
+	}
+
+	existing, reserved := rep.instances[instance.InstanceGuid]
+	if reserved && !existing.Tentative {
+		return 0, types.AlreadyClaimed
+	}
+
+	totals := &rep.totals
+	if reserved {
+		without := rep.totals.clone() //vote as though the reservation hadn't been made yet
+		without.count(existing, -1)
+		totals = &without
+	}
+
+	hasRoom := rep.hasRoomFor(totals, instance)
+	score := rep.score(totals, instance) //recompute score *first*
+	if !hasRoom {
+		return 0, types.InsufficientResources
 	}
 
-	score := rep.score(instance) //recompute score *first*
 	instance.Tentative = true
-	rep.instances[instance.InstanceGuid] = instance //*then* make reservation
+	err = rep.put(instance) //*then* make reservation
+	if err != nil {
+		return 0, err
+	}
 
 	return score, nil
 }
 
-func (rep *Representative) Release(instance instance.Instance) {
+// Preempt reserves an instance even if the rep is full, by stopping claimed instances of
+// lower priority to make room.  Nothing is stopped unless that frees enough room for the
+// instance; what is stopped is returned so that it can be placed elsewhere.
+func (rep *Representative) Preempt(inst instance.Instance) ([]instance.Instance, error) {
+	rep.lock.Lock()
+	defer rep.lock.Unlock()
+
+	err := rep.canRun(inst)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, reserved := rep.instances[inst.InstanceGuid]
+	if reserved && !existing.Tentative {
+		return nil, types.AlreadyClaimed
+	}
+
+	if reserved {
+		return []instance.Instance{}, rep.put(existing) //already made room, refresh the reservation
+	}
+
+	lowerPriority := []instance.Instance{}
+	for _, instance := range rep.instances {
+		if !instance.Tentative && instance.Priority < inst.Priority {
+			lowerPriority = append(lowerPriority, instance)
+		}
+	}
+
+	sort.Sort(byPreemptionPreference(lowerPriority))
+
+	overage := clip(rep.totals.used.Add(inst.Resources).Subtract(rep.capacity))
+	victims, remaining := cover(lowerPriority, overage)
+	if remaining != (instance.Resources{}) {
+		return nil, types.InsufficientResources
+	}
+
+	victims, ok := coverPorts(lowerPriority, victims, inst.Ports-rep.freePorts(&rep.totals))
+	if !ok {
+		return nil, types.InsufficientResources
+	}
+
+	preempted := []instance.Instance{}
+	for _, victim := range victims {
+		err := rep.remove(victim.InstanceGuid)
+		if err != nil {
+			return preempted, err
+		}
+		preempted = append(preempted, victim)
+	}
+
+	inst.Tentative = true
+	return preempted, rep.put(inst)
+}
+
+func (rep *Representative) Release(instance instance.Instance) error {
 	rep.lock.Lock()
 	defer rep.lock.Unlock()
 
 	reservedInstance, ok := rep.instances[instance.InstanceGuid]
-	if !(ok && reservedInstance.Tentative) {
-		panic("wat?")
+	if !ok {
+		return nil
 	}
 
-	delete(rep.instances, instance.InstanceGuid)
+	if !reservedInstance.Tentative {
+		return types.AlreadyClaimed
+	}
+
+	return rep.remove(instance.InstanceGuid)
 }
 
-func (rep *Representative) Claim(instance instance.Instance) {
+// Claim returns the host ports assigned to the instance when it was reserved
+func (rep *Representative) Claim(instance instance.Instance) ([]int, error) {
 	rep.lock.Lock()
 	defer rep.lock.Unlock()
 
-	_, ok := rep.instances[instance.InstanceGuid]
+	reservedInstance, ok := rep.instances[instance.InstanceGuid]
 	if !ok {
-		panic("wat?")
+		return nil, types.UnknownReservation
+	}
+
+	if !reservedInstance.Tentative {
+		return reservedInstance.HostPorts, nil
+	}
+
+	if rep.draining {
+		err := rep.remove(instance.InstanceGuid)
+		if err != nil {
+			return nil, err
+		}
+		return nil, types.Draining
 	}
 
 	instance.Tentative = false
-	rep.instances[instance.InstanceGuid] = instance
+	err := rep.put(instance)
+	if err != nil {
+		return nil, err
+	}
+
+	return rep.instances[instance.InstanceGuid].HostPorts, nil
+}
+
+func (rep *Representative) reapForever() {
+	ttl := rep.reservationTTL
+	if ttl == 0 {
+		ttl = recoveredReservationTTL
+	}
+
+	ticker := time.NewTicker(ttl / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			rep.Reap()
+		case <-rep.done:
+			return
+		}
+	}
+}
+
+// Close stops reaping expired reservations in the background, it is safe to call more than once
+func (rep *Representative) Close() {
+	rep.lock.Lock()
+	defer rep.lock.Unlock()
+
+	select {
+	case <-rep.done:
+	default:
+		close(rep.done)
+	}
+}
+
+// recover restores persisted state.  Tentative instances come back reserved with their
+// original expiry (or a fresh one) so that reservations nobody claims are still reaped.
+func (rep *Representative) recover(state snapshot) {
+	for _, instance := range state.Instances {
+		rep.instances[instance.InstanceGuid] = instance
+		rep.totals.count(instance, 1)
+		if !instance.Tentative {
+			continue
+		}
+
+		expiry, ok := state.Reservations[instance.InstanceGuid]
+		if !ok {
+			ttl := rep.reservationTTL
+			if ttl == 0 {
+				ttl = recoveredReservationTTL
+			}
+			expiry = time.Now().Add(ttl)
+		}
+		rep.reservations[instance.InstanceGuid] = expiry
+	}
 }
 
 // internals -- no locks here the operations above should be atomic
 
-func (rep *Representative) hasRoomFor(instance instance.Instance) bool {
-	return rep.usedResources()+instance.RequiredResources <= rep.totalResources
+// put, remove and replace are the only ways to modify instances, reservations and totals:
+// they journal the change (when persisting) before applying it
+
+// put keeps the host ports of an instance the rep already has and assigns new ones otherwise
+func (rep *Representative) put(instance instance.Instance) error {
+	if existing, ok := rep.instances[instance.InstanceGuid]; ok {
+		instance.HostPorts = existing.HostPorts
+	} else {
+		instance = rep.portRange.assign(instance, rep.totals.allocatedPorts)
+	}
+
+	expiry := time.Time{}
+	if instance.Tentative && rep.reservationTTL > 0 {
+		expiry = time.Now().Add(rep.reservationTTL)
+	}
+
+	if rep.journal != nil {
+		err := rep.journal.put(instance, expiry)
+		if err != nil {
+			return err
+		}
+	}
+
+	if existing, ok := rep.instances[instance.InstanceGuid]; ok {
+		rep.totals.count(existing, -1)
+	}
+	rep.instances[instance.InstanceGuid] = instance
+	rep.totals.count(instance, 1)
+	rep.generation++
+	delete(rep.reservations, instance.InstanceGuid)
+	if !expiry.IsZero() {
+		rep.reservations[instance.InstanceGuid] = expiry
+	}
+
+	rep.compactJournalIfNeeded()
+	return nil
 }
 
-func (rep *Representative) score(instance instance.Instance) float64 {
-	fResources := float64(rep.usedResources()) / float64(rep.totalResources)
-	nInstances := rep.numberOfInstancesForAppGuid(instance.AppGuid)
+func (rep *Representative) remove(instanceGuid string) error {
+	if rep.journal != nil {
+		err := rep.journal.remove(instanceGuid)
+		if err != nil {
+			return err
+		}
+	}
+
+	if existing, ok := rep.instances[instanceGuid]; ok {
+		rep.totals.count(existing, -1)
+		rep.generation++
+		delete(rep.instances, instanceGuid)
+	}
+	delete(rep.reservations, instanceGuid)
 
-	return fResources + float64(nInstances)
+	rep.compactJournalIfNeeded()
+	return nil
 }
 
-func (rep *Representative) usedResources() int {
-	usedResources := 0
-	for _, instance := range rep.instances {
-		usedResources += instance.RequiredResources
+// replace keeps the host ports instances come with, and assigns ports to those that have none
+func (rep *Representative) replace(instances map[string]instance.Instance, reservations map[string]time.Time) error {
+	allocated := map[int]bool{}
+	for _, inst := range instances {
+		for _, port := range inst.HostPorts {
+			allocated[port] = true
+		}
+	}
+
+	for guid, inst := range instances {
+		if inst.Ports > 0 && len(inst.HostPorts) == 0 {
+			inst = rep.portRange.assign(inst, allocated)
+			for _, port := range inst.HostPorts {
+				allocated[port] = true
+			}
+			instances[guid] = inst
+		}
+	}
+
+	if rep.journal != nil {
+		err := rep.journal.compact(snapshotOf(instances, reservations))
+		if err != nil {
+			return err
+		}
+	}
+
+	rep.instances = instances
+	rep.reservations = reservations
+
+	rep.totals = newTotals()
+	for _, instance := range instances {
+		rep.totals.count(instance, 1)
+	}
+	rep.generation++
+
+	return nil
+}
+
+func (rep *Representative) compactJournalIfNeeded() {
+	if rep.journal == nil || !rep.journal.needsCompaction() {
+		return
+	}
+
+	err := rep.journal.compact(snapshotOf(rep.instances, rep.reservations))
+	if err != nil {
+		//the journal still has every change, compaction will be retried after the next one
+		log.Println(rep.guid, "failed to compact journal:", err)
+	}
+}
+
+func snapshotOf(instances map[string]instance.Instance, reservations map[string]time.Time) snapshot {
+	state := snapshot{
+		Instances:    []instance.Instance{},
+		Reservations: map[string]time.Time{},
+	}
+
+	for _, instance := range instances {
+		state.Instances = append(state.Instances, instance)
+	}
+
+	for guid, expiry := range reservations {
+		state.Reservations[guid] = expiry
+	}
+
+	return state
+}
+
+// canRun checks the instance's requirements that no amount of free resources can satisfy
+func (rep *Representative) canRun(instance instance.Instance) error {
+	if rep.draining {
+		return types.Draining
+	}
+
+	if instance.Stack != "" && !rep.stacks[instance.Stack] {
+		return types.IncompatibleStack
+	}
+
+	if instance.Constraint != "" {
+		selector, err := labels.Parse(instance.Constraint)
+		if err != nil {
+			return types.InvalidConstraint
+		}
+
+		if !selector.Matches(rep.labels) {
+			return types.ConstraintMismatch
+		}
 	}
 
-	return usedResources
+	return nil
 }
 
-func (rep *Representative) numberOfInstancesForAppGuid(guid string) int {
-	n := 0
+// evictionCandidates picks claimed instances that cover the overage, preferring
+// instances of the apps the rep runs the most of, then the largest instances
+func (rep *Representative) evictionCandidates(overage instance.Resources) []instance.Instance {
+	claimed := []instance.Instance{}
 	for _, instance := range rep.instances {
-		if instance.AppGuid == guid {
-			n += 1
+		if !instance.Tentative {
+			claimed = append(claimed, instance)
+		}
+	}
+
+	sort.Sort(byEvictionPreference{claimed, rep.totals.appCounts})
+
+	candidates, _ := cover(claimed, overage)
+	return candidates
+}
+
+// cover picks instances, in order, until removing them covers the overage.
+// It returns what it picked and whatever overage remains.
+func cover(instances []instance.Instance, overage instance.Resources) ([]instance.Instance, instance.Resources) {
+	picked := []instance.Instance{}
+	for _, inst := range instances {
+		if overage == (instance.Resources{}) {
+			break
+		}
+
+		reduced := clip(overage.Subtract(inst.Resources))
+		if reduced == overage {
+			continue //doesn't help with any dimension that is still over
+		}
+
+		overage = reduced
+		picked = append(picked, inst)
+	}
+
+	return picked, overage
+}
+
+// coverPorts adds instances (in order) to the victims until they free up the needed
+// number of ports; ok is false if they can't
+func coverPorts(instances []instance.Instance, victims []instance.Instance, needed int) ([]instance.Instance, bool) {
+	picked := map[string]bool{}
+	for _, victim := range victims {
+		picked[victim.InstanceGuid] = true
+		needed -= len(victim.HostPorts)
+	}
+
+	for _, inst := range instances {
+		if needed <= 0 {
+			break
 		}
+
+		if picked[inst.InstanceGuid] || len(inst.HostPorts) == 0 {
+			continue
+		}
+
+		victims = append(victims, inst)
+		needed -= len(inst.HostPorts)
+	}
+
+	return victims, needed <= 0
+}
+
+func (rep *Representative) hasRoomFor(totals *totals, instance instance.Instance) bool {
+	return totals.used.Add(instance.Resources).FitsIn(rep.capacity) && instance.Ports <= rep.freePorts(totals)
+}
+
+func (rep *Representative) freePorts(totals *totals) int {
+	return rep.portRange.Size() - len(totals.allocatedPorts)
+}
+
+func (rep *Representative) score(totals *totals, instance instance.Instance) float64 {
+	return rep.scorer.Score(repView{rep, totals}, instance)
+}
+
+// repView hands the scorer a lock-free view of the rep with the given totals
+
+type repView struct {
+	rep    *Representative
+	totals *totals
+}
+
+func (view repView) TotalResources() instance.Resources {
+	return view.rep.totalResources
+}
+
+func (view repView) UsedResources() instance.Resources {
+	return view.totals.used
+}
+
+func (view repView) NumberOfInstances() int {
+	return view.totals.numInstances
+}
+
+func (view repView) NumberOfInstancesForAppGuid(guid string) int {
+	return view.totals.appCounts[guid]
+}
+
+type byEvictionPreference struct {
+	instances []instance.Instance
+	appCounts map[string]int
+}
+
+func (s byEvictionPreference) Len() int {
+	return len(s.instances)
+}
+
+func (s byEvictionPreference) Swap(i, j int) {
+	s.instances[i], s.instances[j] = s.instances[j], s.instances[i]
+}
+
+func (s byEvictionPreference) Less(i, j int) bool {
+	a, b := s.instances[i], s.instances[j]
+	if s.appCounts[a.AppGuid] != s.appCounts[b.AppGuid] {
+		return s.appCounts[a.AppGuid] > s.appCounts[b.AppGuid]
+	}
+
+	sizeA := a.Resources.MemoryMB + a.Resources.DiskMB
+	sizeB := b.Resources.MemoryMB + b.Resources.DiskMB
+	if sizeA != sizeB {
+		return sizeA > sizeB
+	}
+
+	return a.InstanceGuid < b.InstanceGuid
+}
+
+// byPreemptionPreference preempts the lowest priority instances first, then the largest
+type byPreemptionPreference []instance.Instance
+
+func (s byPreemptionPreference) Len() int {
+	return len(s)
+}
+
+func (s byPreemptionPreference) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s byPreemptionPreference) Less(i, j int) bool {
+	if s[i].Priority != s[j].Priority {
+		return s[i].Priority < s[j].Priority
+	}
+
+	sizeI := s[i].Resources.MemoryMB + s[i].Resources.DiskMB
+	sizeJ := s[j].Resources.MemoryMB + s[j].Resources.DiskMB
+	if sizeI != sizeJ {
+		return sizeI > sizeJ
+	}
+
+	return s[i].InstanceGuid < s[j].InstanceGuid
+}
+
+func clip(r instance.Resources) instance.Resources {
+	if r.MemoryMB < 0 {
+		r.MemoryMB = 0
+	}
+	if r.DiskMB < 0 {
+		r.DiskMB = 0
+	}
+	if r.Containers < 0 {
+		r.Containers = 0
 	}
-	return n
+	return r
 }
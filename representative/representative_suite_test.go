@@ -0,0 +1,13 @@
+package representative_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"testing"
+)
+
+func TestRepresentative(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Representative Suite")
+}
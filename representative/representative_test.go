@@ -0,0 +1,397 @@
+package representative_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/onsi/auction/instance"
+	"github.com/onsi/auction/representative"
+	"github.com/onsi/auction/scoring"
+	"github.com/onsi/auction/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var testResources = instance.Resources{MemoryMB: 1, DiskMB: 1, Containers: 1}
+
+var _ = Describe("Representative", func() {
+	var config representative.Config
+	var rep *representative.Representative
+	var reps []*representative.Representative
+
+	newRep := func() *representative.Representative {
+		rep, err := representative.New("REP", config)
+		Ω(err).ShouldNot(HaveOccurred())
+		reps = append(reps, rep)
+		return rep
+	}
+
+	instancesByGuid := func(rep *representative.Representative) map[string]instance.Instance {
+		instances := map[string]instance.Instance{}
+		for _, inst := range rep.Instances() {
+			instances[inst.InstanceGuid] = inst
+		}
+		return instances
+	}
+
+	//the generation counts refreshes too, so compare everything else
+	stateOf := func(rep *representative.Representative) types.RepState {
+		state := rep.State()
+		state.Generation = 0
+		return state
+	}
+
+	BeforeEach(func() {
+		config = representative.Config{
+			TotalResources: instance.Resources{MemoryMB: 100, DiskMB: 100, Containers: 100},
+		}
+	})
+
+	JustBeforeEach(func() {
+		rep = newRep()
+	})
+
+	AfterEach(func() {
+		for _, rep := range reps {
+			rep.Close()
+		}
+		reps = nil
+	})
+
+	Describe("reserving, claiming and releasing", func() {
+		var inst instance.Instance
+
+		BeforeEach(func() {
+			config.Scorer = scoring.AppDistribution
+			inst = instance.New("APP-B", testResources)
+		})
+
+		JustBeforeEach(func() {
+			err := rep.SetInstances([]instance.Instance{instance.New("APP-A", testResources)})
+			Ω(err).ShouldNot(HaveOccurred())
+		})
+
+		It("should recast the same vote when a reserve is retried", func() {
+			first, err := rep.ReserveAndRecastVote(inst)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			retried, err := rep.ReserveAndRecastVote(inst)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(retried).Should(Equal(first))
+			Ω(rep.State().NumInstances).Should(Equal(2))
+		})
+
+		It("should leave the rep unchanged when a reserve is retried", func() {
+			_, err := rep.ReserveAndRecastVote(inst)
+			Ω(err).ShouldNot(HaveOccurred())
+			reserved := stateOf(rep)
+
+			_, err = rep.ReserveAndRecastVote(inst)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(stateOf(rep)).Should(Equal(reserved))
+		})
+
+		It("should leave the rep unchanged when a claim is retried", func() {
+			_, err := rep.ReserveAndRecastVote(inst)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			_, err = rep.Claim(inst)
+			Ω(err).ShouldNot(HaveOccurred())
+			claimed := stateOf(rep)
+
+			_, err = rep.Claim(inst)
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(stateOf(rep)).Should(Equal(claimed))
+		})
+
+		It("should leave the rep unchanged when a release is retried", func() {
+			before := stateOf(rep)
+
+			_, err := rep.ReserveAndRecastVote(inst)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			for i := 0; i < 2; i++ {
+				err = rep.Release(inst)
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(stateOf(rep)).Should(Equal(before))
+			}
+		})
+	})
+
+	Describe("reporting its state", func() {
+		It("should not report negative free resources once resized below what it runs", func() {
+			err := rep.SetInstances([]instance.Instance{instance.New("APP-A", instance.Resources{MemoryMB: 60, DiskMB: 10, Containers: 1})})
+			Ω(err).ShouldNot(HaveOccurred())
+
+			result := rep.SetTotalResources(instance.Resources{MemoryMB: 50, DiskMB: 50, Containers: 50}, false)
+			Ω(result.OverCommitted).Should(BeTrue())
+
+			Ω(rep.State().FreeResources).Should(Equal(instance.Resources{MemoryMB: 0, DiskMB: 40, Containers: 49}))
+		})
+	})
+
+	Describe("reaping expired reservations", func() {
+		var inst instance.Instance
+
+		BeforeEach(func() {
+			config.ReservationTTL = 20 * time.Millisecond
+			inst = instance.New("APP-A", testResources)
+		})
+
+		It("should reap reservations nobody claims", func() {
+			_, err := rep.ReserveAndRecastVote(inst)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			Eventually(rep.Instances).Should(BeEmpty())
+			Ω(rep.ReapedReservations()).Should(Equal(1))
+		})
+
+		It("should stop reaping once closed", func() {
+			rep.Close()
+			rep.Close()
+
+			_, err := rep.ReserveAndRecastVote(inst)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			Consistently(rep.Instances, 0.1).Should(HaveLen(1))
+		})
+	})
+
+	Describe("voting on a batch", func() {
+		var batch []instance.Instance
+
+		BeforeEach(func() {
+			config.Scorer = scoring.AppDistribution
+
+			batch = []instance.Instance{}
+			for i := 0; i < 4; i++ {
+				batch = append(batch, instance.New("APP-A", instance.Resources{MemoryMB: 30, DiskMB: 30, Containers: 1}))
+			}
+		})
+
+		It("should not place anything", func() {
+			Ω(rep.VoteBatch(batch)).Should(HaveLen(len(batch)))
+			Ω(rep.Instances()).Should(BeEmpty())
+		})
+
+		It("should score each instance as if the earlier ones were placed", func() {
+			votes := rep.VoteBatch(batch)
+
+			placed := newRep()
+			for i, inst := range batch[:3] {
+				vote, err := placed.Vote(inst)
+				Ω(err).ShouldNot(HaveOccurred())
+				Ω(votes[i].Error).Should(BeEmpty())
+				Ω(votes[i].Score).Should(Equal(vote.Score))
+				Ω(votes[i].AppInstances).Should(Equal(vote.AppInstances))
+
+				_, err = placed.ReserveAndRecastVote(inst)
+				Ω(err).ShouldNot(HaveOccurred())
+			}
+
+			Ω(votes[3].Error).Should(Equal(types.InsufficientResources.Error()))
+		})
+	})
+
+	Describe("persisting to a data dir", func() {
+		var dataDir string
+		var inst instance.Instance
+
+		//the expiries a recovering rep compacted into its snapshot
+		persistedReservations := func() map[string]time.Time {
+			data, err := os.ReadFile(filepath.Join(dataDir, "snapshot.json"))
+			Ω(err).ShouldNot(HaveOccurred())
+
+			var snapshot struct {
+				Reservations map[string]time.Time `json:"reservations"`
+			}
+			err = json.Unmarshal(data, &snapshot)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			return snapshot.Reservations
+		}
+
+		BeforeEach(func() {
+			var err error
+			dataDir, err = os.MkdirTemp("", "representative")
+			Ω(err).ShouldNot(HaveOccurred())
+
+			config.DataDir = dataDir
+			inst = instance.New("APP-A", testResources)
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(dataDir)
+		})
+
+		It("should recover claimed and tentative instances", func() {
+			tentative := instance.New("APP-B", testResources)
+			stopped := instance.New("APP-C", testResources)
+			for _, reserved := range []instance.Instance{inst, tentative, stopped} {
+				_, err := rep.ReserveAndRecastVote(reserved)
+				Ω(err).ShouldNot(HaveOccurred())
+			}
+
+			_, err := rep.Claim(inst)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			err = rep.Release(stopped)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			instances := instancesByGuid(newRep())
+			Ω(instances).Should(HaveLen(2))
+			Ω(instances).Should(HaveKey(inst.InstanceGuid))
+			Ω(instances[inst.InstanceGuid].Tentative).Should(BeFalse())
+			Ω(instances).Should(HaveKey(tentative.InstanceGuid))
+			Ω(instances[tentative.InstanceGuid].Tentative).Should(BeTrue())
+		})
+
+		Context("with a reservation TTL", func() {
+			BeforeEach(func() {
+				config.ReservationTTL = time.Hour
+			})
+
+			It("should keep a recovered reservation's expiry", func() {
+				before := time.Now()
+				_, err := rep.ReserveAndRecastVote(inst)
+				Ω(err).ShouldNot(HaveOccurred())
+				after := time.Now()
+
+				config.ReservationTTL = time.Minute
+				newRep()
+
+				reservations := persistedReservations()
+				Ω(reservations).Should(HaveKey(inst.InstanceGuid))
+				Ω(reservations[inst.InstanceGuid].Before(before.Add(time.Hour))).Should(BeFalse())
+				Ω(reservations[inst.InstanceGuid].After(after.Add(time.Hour))).Should(BeFalse())
+			})
+		})
+
+		It("should give recovered reservations a fresh expiry when there is no TTL", func() {
+			_, err := rep.ReserveAndRecastVote(inst)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			before := time.Now()
+			recovered := newRep()
+			after := time.Now()
+
+			reservations := persistedReservations()
+			Ω(reservations).Should(HaveKey(inst.InstanceGuid))
+			Ω(reservations[inst.InstanceGuid].Before(before.Add(30 * time.Second))).Should(BeFalse())
+			Ω(reservations[inst.InstanceGuid].After(after.Add(30 * time.Second))).Should(BeFalse())
+			Ω(recovered.Reap()).Should(BeZero())
+		})
+
+		It("should ignore a torn last entry", func() {
+			_, err := rep.ReserveAndRecastVote(inst)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			log, err := os.OpenFile(filepath.Join(dataDir, "journal.log"), os.O_WRONLY|os.O_APPEND, 0644)
+			Ω(err).ShouldNot(HaveOccurred())
+			_, err = log.WriteString(`{"op":"remove","instance_gu`)
+			log.Close()
+			Ω(err).ShouldNot(HaveOccurred())
+
+			recovered := newRep()
+			Ω(instancesByGuid(recovered)).Should(HaveKey(inst.InstanceGuid))
+
+			_, err = recovered.Claim(inst)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			instances := instancesByGuid(newRep())
+			Ω(instances).Should(HaveKey(inst.InstanceGuid))
+			Ω(instances[inst.InstanceGuid].Tentative).Should(BeFalse())
+		})
+
+		It("should compact the journal", func() {
+			_, err := rep.ReserveAndRecastVote(inst)
+			Ω(err).ShouldNot(HaveOccurred())
+			_, err = rep.Claim(inst)
+			Ω(err).ShouldNot(HaveOccurred())
+
+			churned := instance.New("APP-B", testResources)
+			for i := 0; i < 1000; i++ {
+				_, err := rep.ReserveAndRecastVote(churned)
+				Ω(err).ShouldNot(HaveOccurred())
+				err = rep.Release(churned)
+				Ω(err).ShouldNot(HaveOccurred())
+			}
+
+			data, err := os.ReadFile(filepath.Join(dataDir, "journal.log"))
+			Ω(err).ShouldNot(HaveOccurred())
+			Ω(strings.Count(string(data), "\n")).Should(BeNumerically("<", 1000))
+
+			instances := instancesByGuid(newRep())
+			Ω(instances).Should(HaveLen(1))
+			Ω(instances).Should(HaveKey(inst.InstanceGuid))
+			Ω(instances[inst.InstanceGuid].Tentative).Should(BeFalse())
+		})
+	})
+})
+
+var benchmarkSizes = []int{10, 100, 1000, 10000}
+
+func newBenchmarkRep(b *testing.B, numInstances int) *representative.Representative {
+	rep, err := representative.New("REP", representative.Config{
+		TotalResources: instance.Resources{MemoryMB: 2 * numInstances, DiskMB: 2 * numInstances, Containers: 2 * numInstances},
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	instances := []instance.Instance{}
+	for i := 0; i < numInstances; i++ {
+		instances = append(instances, instance.New(fmt.Sprintf("APP-%d", i%50), testResources))
+	}
+
+	err = rep.SetInstances(instances)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return rep
+}
+
+func BenchmarkVote(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		b.Run(fmt.Sprintf("%d instances", size), func(b *testing.B) {
+			rep := newBenchmarkRep(b, size)
+			inst := instance.New("APP-0", testResources)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err := rep.Vote(inst)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkReserveAndRelease(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		b.Run(fmt.Sprintf("%d instances", size), func(b *testing.B) {
+			rep := newBenchmarkRep(b, size)
+			inst := instance.New("APP-0", testResources)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, err := rep.ReserveAndRecastVote(inst)
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				err = rep.Release(inst)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package representative
+
+import "github.com/onsi/auction/instance"
+
+// totals are running sums over a rep's instances, so that votes don't have to walk them
+type totals struct {
+	used           instance.Resources
+	reserved       instance.Resources
+	numInstances   int
+	appCounts      map[string]int
+	allocatedPorts map[int]bool
+}
+
+func newTotals() totals {
+	return totals{
+		appCounts:      map[string]int{},
+		allocatedPorts: map[int]bool{},
+	}
+}
+
+// count adds (or, with -1, subtracts) an instance to the totals
+func (t *totals) count(inst instance.Instance, sign int) {
+	if sign > 0 {
+		t.used = t.used.Add(inst.Resources)
+		if inst.Tentative {
+			t.reserved = t.reserved.Add(inst.Resources)
+		}
+	} else {
+		t.used = t.used.Subtract(inst.Resources)
+		if inst.Tentative {
+			t.reserved = t.reserved.Subtract(inst.Resources)
+		}
+	}
+
+	t.numInstances += sign
+
+	t.appCounts[inst.AppGuid] += sign
+	if t.appCounts[inst.AppGuid] == 0 {
+		delete(t.appCounts, inst.AppGuid)
+	}
+
+	for _, port := range inst.HostPorts {
+		if sign > 0 {
+			t.allocatedPorts[port] = true
+		} else {
+			delete(t.allocatedPorts, port)
+		}
+	}
+}
+
+// clone copies the totals so that they can be changed without touching the rep's
+func (t totals) clone() totals {
+	clone := t
+	clone.appCounts = map[string]int{}
+	for appGuid, count := range t.appCounts {
+		clone.appCounts[appGuid] = count
+	}
+
+	clone.allocatedPorts = map[int]bool{}
+	for port := range t.allocatedPorts {
+		clone.allocatedPorts[port] = true
+	}
+
+	return clone
+}
@@ -0,0 +1,130 @@
+package scoring
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/onsi/auction/instance"
+)
+
+// Composite scores with a weighted sum of terms.  It is typically loaded from a JSON file:
+//
+//	{
+//	  "terms": [
+//	    {"name": "resources", "weight": 1},
+//	    {"name": "app_instances", "weight": 0.5, "normalization": "instances"}
+//	  ]
+//	}
+//
+// Default is equivalent to a weight of 1 on both terms with no normalization.
+type Composite struct {
+	Terms []Term `json:"terms"`
+}
+
+type Term struct {
+	Name          string  `json:"name"`
+	Weight        float64 `json:"weight"`
+	Normalization string  `json:"normalization,omitempty"`
+}
+
+type termFunc func(rep Rep, instance instance.Instance) float64
+
+var terms = map[string]termFunc{
+	// fraction of the rep's resources in use, averaged across dimensions
+	"resources": func(rep Rep, instance instance.Instance) float64 {
+		return rep.UsedResources().FractionOf(rep.TotalResources())
+	},
+
+	// number of instances of the same app already on the rep
+	"app_instances": func(rep Rep, instance instance.Instance) float64 {
+		return float64(rep.NumberOfInstancesForAppGuid(instance.AppGuid))
+	},
+}
+
+// normalizations divide a term's value to bring it into a comparable range
+var normalizations = map[string]func(rep Rep) float64{
+	"none": func(rep Rep) float64 {
+		return 1
+	},
+
+	// relative to the number of instances on the rep
+	"instances": func(rep Rep) float64 {
+		return float64(rep.NumberOfInstances())
+	},
+
+	// relative to the number of containers the rep can hold
+	"containers": func(rep Rep) float64 {
+		return float64(rep.TotalResources().Containers)
+	},
+}
+
+func LoadComposite(path string) (Composite, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Composite{}, err
+	}
+
+	defer file.Close()
+
+	var composite Composite
+	err = json.NewDecoder(file).Decode(&composite)
+	if err != nil {
+		return Composite{}, fmt.Errorf("invalid scoring config %s: %s", path, err)
+	}
+
+	err = composite.Validate()
+	if err != nil {
+		return Composite{}, fmt.Errorf("invalid scoring config %s: %s", path, err)
+	}
+
+	return composite, nil
+}
+
+func (composite Composite) Validate() error {
+	if len(composite.Terms) == 0 {
+		return fmt.Errorf("no terms")
+	}
+
+	for _, term := range composite.Terms {
+		if _, ok := terms[term.Name]; !ok {
+			return fmt.Errorf("unknown term %q", term.Name)
+		}
+
+		if term.Normalization == "" {
+			continue
+		}
+
+		if _, ok := normalizations[term.Normalization]; !ok {
+			return fmt.Errorf("unknown normalization %q for term %q", term.Normalization, term.Name)
+		}
+	}
+
+	return nil
+}
+
+// Score assumes the Composite is valid; unknown terms are ignored
+func (composite Composite) Score(rep Rep, instance instance.Instance) float64 {
+	score := 0.0
+	for _, term := range composite.Terms {
+		value := terms[term.Name]
+		if value == nil {
+			continue
+		}
+
+		v := value(rep, instance)
+
+		if normalization, ok := normalizations[term.Normalization]; ok {
+			divisor := normalization(rep)
+			if divisor == 0 {
+				v = 0
+			} else {
+				v = v / divisor
+			}
+		}
+
+		score += term.Weight * v
+	}
+
+	return score
+}
@@ -0,0 +1,77 @@
+package scoring
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/onsi/auction/instance"
+)
+
+// Rep is the view of a representative that a Scorer gets to look at.
+// Representatives call Score while holding their lock, so implementations must not block.
+type Rep interface {
+	TotalResources() instance.Resources
+	UsedResources() instance.Resources
+	NumberOfInstances() int
+	NumberOfInstancesForAppGuid(appGuid string) int
+}
+
+// Scorer computes a rep's bid for an instance -- lower scores win the auction
+type Scorer interface {
+	Score(rep Rep, instance instance.Instance) float64
+}
+
+type ScorerFunc func(rep Rep, instance instance.Instance) float64
+
+func (f ScorerFunc) Score(rep Rep, instance instance.Instance) float64 {
+	return f(rep, instance)
+}
+
+// Default balances resource usage and spreads instances of the same app
+var Default = ScorerFunc(func(rep Rep, instance instance.Instance) float64 {
+	fResources := rep.UsedResources().FractionOf(rep.TotalResources())
+	nInstances := rep.NumberOfInstancesForAppGuid(instance.AppGuid)
+
+	return fResources + float64(nInstances)
+})
+
+// Spread favors the emptiest reps and ignores which apps they are running
+var Spread = ScorerFunc(func(rep Rep, instance instance.Instance) float64 {
+	return rep.UsedResources().FractionOf(rep.TotalResources())
+})
+
+// BinPack favors the fullest reps that still have room, leaving empty reps for large instances
+var BinPack = ScorerFunc(func(rep Rep, instance instance.Instance) float64 {
+	return 1 - rep.UsedResources().FractionOf(rep.TotalResources())
+})
+
+// AppDistribution favors reps running the fewest instances of the app, breaking ties by instance count
+var AppDistribution = ScorerFunc(func(rep Rep, instance instance.Instance) float64 {
+	nInstances := rep.NumberOfInstancesForAppGuid(instance.AppGuid)
+	return float64(nInstances) + float64(rep.NumberOfInstances())/float64(rep.NumberOfInstances()+1)
+})
+
+var Strategies = map[string]Scorer{
+	"default":          Default,
+	"spread":           Spread,
+	"binpack":          BinPack,
+	"app-distribution": AppDistribution,
+}
+
+func Lookup(name string) (Scorer, error) {
+	scorer, ok := Strategies[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown scoring strategy %q (known: %v)", name, StrategyNames())
+	}
+
+	return scorer, nil
+}
+
+func StrategyNames() []string {
+	names := []string{}
+	for name := range Strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
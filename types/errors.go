@@ -0,0 +1,62 @@
+package types
+
+import (
+	"context"
+	"errors"
+)
+
+var InsufficientResources = errors.New("insufficient resources for instance")
+var IncompatibleStack = errors.New("stack not supported by representative")
+var ConstraintMismatch = errors.New("representative labels do not satisfy instance constraint")
+var InvalidConstraint = errors.New("invalid instance constraint")
+var UnknownReservation = errors.New("unknown reservation")
+var AlreadyClaimed = errors.New("instance already claimed")
+var UnknownInstance = errors.New("unknown instance")
+var Draining = errors.New("representative is draining")
+var NotDraining = errors.New("representative is not draining")
+var TimeoutError = errors.New("timeout")
+var RequestFailedError = errors.New("request failed")
+
+var knownErrors = []error{
+	InsufficientResources,
+	IncompatibleStack,
+	ConstraintMismatch,
+	InvalidConstraint,
+	UnknownReservation,
+	AlreadyClaimed,
+	UnknownInstance,
+	Draining,
+	NotDraining,
+	TimeoutError,
+	RequestFailedError,
+	context.Canceled,
+	context.DeadlineExceeded,
+}
+
+// RetryTimeouts calls f until it fails with something other than a timeout, retrying at most retries times
+func RetryTimeouts(retries int, f func() error) (err error) {
+	for attempt := 0; attempt <= retries; attempt++ {
+		err = f()
+		if err != TimeoutError {
+			return err
+		}
+	}
+
+	return err
+}
+
+// ErrorFromString turns an error message that came over the wire back into the
+// matching sentinel error, so callers can compare errors regardless of transport
+func ErrorFromString(message string) error {
+	if message == "" {
+		return nil
+	}
+
+	for _, err := range knownErrors {
+		if err.Error() == message {
+			return err
+		}
+	}
+
+	return errors.New(message)
+}
@@ -1,29 +1,88 @@
 package types
 
 import (
+	"context"
 	"time"
 
 	"github.com/onsi/auction/instance"
 )
 
 type VoteResult struct {
-	Rep   string  `json:"r"`
-	Score float64 `json:"s"`
-	Error string  `json:"e"`
+	Rep          string  `json:"r"`
+	Score        float64 `json:"s"`
+	Zone         string  `json:"z"`
+	AppInstances int     `json:"a"`
+	Error        string  `json:"e"`
 }
 
 type AuctionRequest struct {
 	Instance instance.Instance `json:"i"`
 	RepGuids []string          `json:"rg"`
 	Rules    AuctionRules      `json:"r"`
+
+	// when set, the auction is abandoned (and its reservation released) at this time
+	Deadline time.Time `json:"dl,omitempty"`
+
+	// the placement algorithm to use, empty for the default reserve-and-recast auction
+	Algorithm string `json:"a,omitempty"`
 }
 
+// AuctionOutcome says how an auction ended
+type AuctionOutcome string
+
+const (
+	OutcomePlaced                    AuctionOutcome = "placed"
+	OutcomeAllBiddersFull            AuctionOutcome = "all_bidders_full"
+	OutcomeRoundsExhausted           AuctionOutcome = "rounds_exhausted"
+	OutcomeNoMatchingRepresentatives AuctionOutcome = "no_matching_representatives"
+	OutcomeTimedOut                  AuctionOutcome = "timed_out"
+	OutcomeCancelled                 AuctionOutcome = "cancelled"
+	OutcomeTransportError            AuctionOutcome = "transport_error"
+	OutcomeInvalidRequest            AuctionOutcome = "invalid_request"
+
+	// the caller gave up on a remote auction, which may still have placed the instance
+	OutcomeUnknown AuctionOutcome = "unknown"
+)
+
 type AuctionResult struct {
 	Instance  instance.Instance `json:"i"`
 	Winner    string            `json:"w"`
+	Outcome   AuctionOutcome    `json:"o"`
 	NumRounds int               `json:"nr"`
 	NumVotes  int               `json:"nv"`
 	Duration  time.Duration     `json:"d"`
+	Error     string            `json:"e"`
+
+	// for each round, how many bidders failed with each error
+	RoundErrors []map[string]int `json:"re,omitempty"`
+
+	// instances stopped to make room for this one, they need to be auctioned again
+	Preempted []instance.Instance `json:"p,omitempty"`
+}
+
+// AppAuctionRequest places NumInstances copies of Instance (an instance of the app, whose
+// InstanceGuid is ignored) together, so that they spread across the bidding pool
+type AppAuctionRequest struct {
+	Instance     instance.Instance `json:"i"`
+	NumInstances int               `json:"n"`
+	RepGuids     []string          `json:"rg"`
+	Rules        AuctionRules      `json:"r"`
+}
+
+type AppAuctionResult struct {
+	AppGuid    string              `json:"ag"`
+	Placements []AppPlacement      `json:"p"`
+	Unplaced   []instance.Instance `json:"u,omitempty"`
+	Outcome    AuctionOutcome      `json:"o"`
+	NumRounds  int                 `json:"nr"`
+	NumVotes   int                 `json:"nv"`
+	Duration   time.Duration       `json:"d"`
+	Error      string              `json:"e"`
+}
+
+type AppPlacement struct {
+	Instance instance.Instance `json:"i"`
+	Rep      string            `json:"r"`
 }
 
 type AuctionRules struct {
@@ -31,22 +90,91 @@ type AuctionRules struct {
 	MaxBiddingPool   int  `json:"mb"`
 	MaxConcurrent    int  `json:"mc"`
 	RepickEveryRound bool `json:"r"`
+
+	// how strongly to avoid zones that already run more of the app than the others
+	ZoneBalanceWeight float64 `json:"zw"`
+
+	// when every bidder is full, whether to stop lower priority instances to make room
+	AllowPreemption bool `json:"ap"`
+
+	// how many of the best first round bidders (besides the winner) vote again, 0 for all of them
+	SecondRoundTopK int `json:"k"`
 }
 
-type AuctionCommunicator func(AuctionRequest) AuctionResult
+// RepState is a consistent view of a rep's utilization.  Used includes Reserved
+// (tentative instances) and Free is measured against the (possibly overcommitted) Capacity,
+// bottoming out at zero when a resize leaves the rep running more than it has.
+// Generation changes whenever the rep's instances do.
+type RepState struct {
+	Rep                string             `json:"rep"`
+	Zone               string             `json:"zone"`
+	TotalResources     instance.Resources `json:"total"`
+	Capacity           instance.Resources `json:"capacity"`
+	UsedResources      instance.Resources `json:"used"`
+	ReservedResources  instance.Resources `json:"reserved"`
+	FreeResources      instance.Resources `json:"free"`
+	FreePorts          int                `json:"free_ports"`
+	NumInstances       int                `json:"num_instances"`
+	AppInstances       map[string]int     `json:"app_instances"`
+	Generation         uint64             `json:"generation"`
+	Draining           bool               `json:"draining"`
+	ReapedReservations int                `json:"reaped"`
+}
+
+// ResizeRequest asks a rep to change its total resources, optionally nominating
+// instances to evict if it ends up running more than its new capacity
+type ResizeRequest struct {
+	TotalResources    instance.Resources `json:"total"`
+	NominateEvictions bool               `json:"nominate_evictions"`
+}
 
+type ResizeResult struct {
+	Capacity           instance.Resources  `json:"capacity"`
+	OverCommitted      bool                `json:"over_committed"`
+	Overage            instance.Resources  `json:"overage"`
+	EvictionCandidates []instance.Instance `json:"eviction_candidates"`
+}
+
+type AuctionCommunicator func(context.Context, AuctionRequest, AuctionObserver) AuctionResult
+
+// AuctionObserver is told about each step of an auction as it happens.  Concurrent auctions
+// share observers, so implementations must be safe for concurrent use and must not block.
+type AuctionObserver interface {
+	RoundStarted(request AuctionRequest, round int, bidders []string)
+	VotesReceived(request AuctionRequest, round int, votes []VoteResult)
+	WinnerChosen(request AuctionRequest, round int, winner string, score float64)
+	ReserveCompleted(request AuctionRequest, round int, rep string, score float64, err error)
+	SecondRoundCompleted(request AuctionRequest, round int, votes []VoteResult, secondPlaceScore float64, err error)
+	Released(request AuctionRequest, round int, rep string, err error)
+	ClaimCompleted(request AuctionRequest, round int, rep string, hostPorts []int, err error)
+	AuctionCompleted(request AuctionRequest, result AuctionResult)
+}
+
+// RepPoolClient methods fail with the sentinel errors in errors.go no matter the transport,
+// or with the context's error once it is cancelled or past its deadline
 type RepPoolClient interface {
-	Vote(guids []string, instance instance.Instance) []VoteResult
-	ReserveAndRecastVote(guid string, instance instance.Instance) (float64, error)
-	Release(guid string, instance instance.Instance)
-	Claim(guid string, instance instance.Instance)
+	Vote(ctx context.Context, guids []string, instance instance.Instance) ([]VoteResult, error)
+	VoteBatch(ctx context.Context, guid string, instances []instance.Instance) ([]VoteResult, error)
+	ReserveAndRecastVote(ctx context.Context, guid string, instance instance.Instance) (float64, error)
+	Preempt(ctx context.Context, guid string, instance instance.Instance) ([]instance.Instance, error)
+	Release(ctx context.Context, guid string, instance instance.Instance) error
+	Claim(ctx context.Context, guid string, instance instance.Instance) ([]int, error)
+	Stop(ctx context.Context, guid string, instanceGuid string) error
+
+	Drain(ctx context.Context, guid string) ([]instance.Instance, error)
+	ReleaseEvacuated(ctx context.Context, guid string, instance instance.Instance) error
 }
 
 type TestRepPoolClient interface {
 	RepPoolClient
 
-	TotalResources(guid string) int
-	Instances(guid string) []instance.Instance
-	SetInstances(guid string, instances []instance.Instance)
-	Reset(guid string)
+	TotalResources(guid string) (instance.Resources, error)
+	Capacity(guid string) (instance.Resources, error)
+	SetTotalResources(guid string, request ResizeRequest) (ResizeResult, error)
+	Zone(guid string) (string, error)
+	ReapedReservations(guid string) (int, error)
+	State(guid string) (RepState, error)
+	Instances(guid string) ([]instance.Instance, error)
+	SetInstances(guid string, instances []instance.Instance) error
+	Reset(guid string) error
 }
@@ -2,9 +2,12 @@ package visualization
 
 import (
 	"fmt"
+	"math"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/onsi/auction/instance"
 	"github.com/onsi/auction/lossyrep"
 	"github.com/onsi/auction/types"
 )
@@ -47,49 +50,62 @@ func PrintReport(client types.TestRepPoolClient, results []types.AuctionResult,
 	}
 	guidFormat := fmt.Sprintf("%%%ds", maxGuidLength)
 
-	numNew := 0
+	zones := []string{}
+	repsByZone := map[string][]string{}
 	for _, guid := range representatives {
-		repString := fmt.Sprintf(guidFormat, guid)
-		lossyRep, ok := client.(*lossyrep.LossyRep)
-		if ok && lossyRep.FlakyReps[guid] {
-			repString = fmt.Sprintf("%s"+guidFormat+"%s", redColor, repString, defaultStyle)
+		zone, err := client.Zone(guid)
+		if err != nil {
+			zone = "unknown zone"
 		}
+		if _, ok := repsByZone[zone]; !ok {
+			zones = append(zones, zone)
+		}
+		repsByZone[zone] = append(repsByZone[zone], guid)
+	}
+	sort.Strings(zones)
 
-		instanceString := ""
-		instances := client.Instances(guid)
-
-		availableColors := []string{"red", "cyan", "yellow", "gray", "plurple", "green"}
-		colorLookup := map[string]string{"red": redColor, "green": greenColor, "cyan": cyanColor, "yellow": yellowColor, "gray": lightGrayColor, "plurple": plurpleColor}
+	appZoneCounts := map[string]map[string]int{}
+	zoneInstanceCounts := map[string]int{}
 
-		originalCounts := map[string]int{}
-		newCounts := map[string]int{}
-		for _, instance := range instances {
-			key := "green"
-			if _, ok := colorLookup[instance.AppGuid]; ok {
-				key = instance.AppGuid
-			}
-			if auctionedInstances[instance.InstanceGuid] {
-				newCounts[key] += 1
-				numNew += 1
-			} else {
-				originalCounts[key] += 1
-			}
+	numNew, numReaped := 0, 0
+	headroom := &headroomTally{}
+	quality := &qualityTally{}
+	for _, zone := range zones {
+		if zone != "" {
+			fmt.Printf("  %s[%s]%s\n", boldStyle, zone, defaultStyle)
 		}
-		for _, col := range availableColors {
-			instanceString += strings.Repeat(colorLookup[col]+"○"+defaultStyle, originalCounts[col])
-			instanceString += strings.Repeat(colorLookup[col]+"●"+defaultStyle, newCounts[col])
+		for _, guid := range repsByZone[zone] {
+			repNew, repInstances, repReaped := printRep(client, guid, guidFormat, auctionedInstances, zone, appZoneCounts, headroom, quality)
+			numNew += repNew
+			zoneInstanceCounts[zone] += repInstances
+			numReaped += repReaped
 		}
-		instanceString += strings.Repeat(grayColor+"○"+defaultStyle, client.TotalResources(guid)-len(instances))
+	}
 
-		fmt.Printf("  %s: %s\n", repString, instanceString)
+	if len(zones) > 1 {
+		printZones(zones, repsByZone, zoneInstanceCounts, appZoneCounts)
 	}
 
+	headroom.print()
+	quality.print()
+
 	fmt.Printf("Finished %d Auctions among %d Representatives in %s\n", len(results), len(representatives), duration)
 	if numNew < len(auctionedInstances) {
 		expected := len(auctionedInstances)
-		fmt.Printf("  %s!!!!MISSING INSTANCES!!!!  Expected %d, got %d (%.3f %% failure rate)%s", redColor, expected, numNew, float64(expected-numNew)/float64(expected), defaultStyle)
+		fmt.Printf("  %s!!!!MISSING INSTANCES!!!!  Expected %d, got %d (%.3f %% failure rate)%s\n", redColor, expected, numNew, float64(expected-numNew)/float64(expected), defaultStyle)
 	}
-	fmt.Printf("  MaxConcurrent: %d, MaxBiddingBool:%d, RepickEveryRound: %t, MaxRounds: %d\n", rules.MaxConcurrent, rules.MaxBiddingPool, rules.RepickEveryRound, rules.MaxRounds)
+	numPreempted := 0
+	for _, result := range results {
+		numPreempted += len(result.Preempted)
+	}
+	printFailures(results)
+	if numPreempted > 0 {
+		fmt.Printf("  %sPreempted %d lower priority instances%s\n", yellowColor, numPreempted, defaultStyle)
+	}
+	if numReaped > 0 {
+		fmt.Printf("  %sReaped %d expired reservations%s\n", yellowColor, numReaped, defaultStyle)
+	}
+	fmt.Printf("  MaxConcurrent: %d, MaxBiddingBool:%d, RepickEveryRound: %t, MaxRounds: %d, ZoneBalanceWeight: %.2f, AllowPreemption: %t, SecondRoundTopK: %d\n", rules.MaxConcurrent, rules.MaxBiddingPool, rules.RepickEveryRound, rules.MaxRounds, rules.ZoneBalanceWeight, rules.AllowPreemption, rules.SecondRoundTopK)
 	if _, ok := client.(*lossyrep.LossyRep); ok {
 		fmt.Printf("  Latency Range: %s < %s, Timeout: %s, Flakiness: %.2f\n", lossyrep.LatencyMin, lossyrep.LatencyMax, lossyrep.Timeout, lossyrep.Flakiness)
 	}
@@ -149,3 +165,309 @@ func PrintReport(client types.TestRepPoolClient, results []types.AuctionResult,
 	fmt.Printf("  Min: %d | Max: %d | Total: %d | Mean: %.2f\n", minVotes, maxVotes, totalVotes, meanVotes)
 
 }
+
+func printRep(client types.TestRepPoolClient, guid string, guidFormat string, auctionedInstances map[string]bool, zone string, appZoneCounts map[string]map[string]int, headroom *headroomTally, quality *qualityTally) (int, int, int) {
+	repString := fmt.Sprintf(guidFormat, guid)
+	lossyRep, ok := client.(*lossyrep.LossyRep)
+	if ok && lossyRep.FlakyReps[guid] {
+		repString = fmt.Sprintf("%s"+guidFormat+"%s", redColor, repString, defaultStyle)
+	}
+
+	instanceString := ""
+	instances, err := client.Instances(guid)
+	if err != nil {
+		fmt.Printf("  %s: %sfailed to fetch instances: %s%s\n", repString, redColor, err, defaultStyle)
+		return 0, 0, 0
+	}
+
+	state, err := client.State(guid)
+	if err != nil {
+		fmt.Printf("  %s: %sfailed to fetch state: %s%s\n", repString, redColor, err, defaultStyle)
+		return 0, 0, 0
+	}
+	totalResources, capacity, usedResources := state.TotalResources, state.Capacity, state.UsedResources
+
+	availableColors := []string{"red", "cyan", "yellow", "gray", "plurple", "green"}
+	colorLookup := map[string]string{"red": redColor, "green": greenColor, "cyan": cyanColor, "yellow": yellowColor, "gray": lightGrayColor, "plurple": plurpleColor}
+
+	numNew := 0
+	originalCounts := map[string]int{}
+	newCounts := map[string]int{}
+	for _, instance := range instances {
+		if appZoneCounts[instance.AppGuid] == nil {
+			appZoneCounts[instance.AppGuid] = map[string]int{}
+		}
+		appZoneCounts[instance.AppGuid][zone] += 1
+
+		key := "green"
+		if _, ok := colorLookup[instance.AppGuid]; ok {
+			key = instance.AppGuid
+		}
+		if auctionedInstances[instance.InstanceGuid] {
+			newCounts[key] += 1
+			numNew += 1
+		} else {
+			originalCounts[key] += 1
+		}
+	}
+	for _, col := range availableColors {
+		instanceString += strings.Repeat(colorLookup[col]+"○"+defaultStyle, originalCounts[col])
+		instanceString += strings.Repeat(colorLookup[col]+"●"+defaultStyle, newCounts[col])
+	}
+	instanceString += strings.Repeat(grayColor+"○"+defaultStyle, nonNegative(capacity.Containers-usedResources.Containers))
+
+	resourcesString := fmt.Sprintf("%smem: %d/%d%s disk: %d/%d%s", grayColor,
+		usedResources.MemoryMB, totalResources.MemoryMB, overcommitString(totalResources.MemoryMB, capacity.MemoryMB),
+		usedResources.DiskMB, totalResources.DiskMB, overcommitString(totalResources.DiskMB, capacity.DiskMB))
+	if state.ReservedResources.Containers > 0 {
+		resourcesString += fmt.Sprintf(" %s%d tentative%s", yellowColor, state.ReservedResources.Containers, grayColor)
+	}
+	if state.Draining {
+		resourcesString += " draining"
+	}
+	resourcesString += defaultStyle
+
+	headroom.add(totalResources, capacity, usedResources)
+	quality.add(instances, capacity, usedResources)
+
+	fmt.Printf("  %s: %s %s\n", repString, instanceString, resourcesString)
+
+	return numNew, len(instances), state.ReapedReservations
+}
+
+// printFailures breaks failed auctions down by outcome (and error), and totals the errors
+// bidders returned across every round
+func printFailures(results []types.AuctionResult) {
+	outcomes := map[types.AuctionOutcome]map[string]int{}
+	bidderErrors := map[string]int{}
+	for _, result := range results {
+		for _, roundErrors := range result.RoundErrors {
+			for reason, count := range roundErrors {
+				bidderErrors[reason] += count
+			}
+		}
+
+		if result.Winner != "" {
+			continue
+		}
+
+		outcome := result.Outcome
+		if outcome == "" {
+			outcome = "unknown"
+		}
+		if outcomes[outcome] == nil {
+			outcomes[outcome] = map[string]int{}
+		}
+		outcomes[outcome][result.Error]++
+	}
+
+	for _, outcome := range sortedOutcomes(outcomes) {
+		total := 0
+		for _, count := range outcomes[outcome] {
+			total += count
+		}
+		fmt.Printf("  %s%d auctions failed: %s%s\n", redColor, total, outcome, defaultStyle)
+		for _, reason := range sortedKeys(outcomes[outcome]) {
+			if reason != "" && reason != string(outcome) {
+				fmt.Printf("    %d: %s\n", outcomes[outcome][reason], reason)
+			}
+		}
+	}
+
+	if len(bidderErrors) > 0 {
+		fmt.Printf("  %sBidder errors:", grayColor)
+		for _, reason := range sortedKeys(bidderErrors) {
+			fmt.Printf(" %s (%d)", reason, bidderErrors[reason])
+		}
+		fmt.Printf("%s\n", defaultStyle)
+	}
+}
+
+func sortedOutcomes(outcomes map[types.AuctionOutcome]map[string]int) []types.AuctionOutcome {
+	names := []string{}
+	for outcome := range outcomes {
+		names = append(names, string(outcome))
+	}
+	sort.Strings(names)
+
+	sorted := []types.AuctionOutcome{}
+	for _, name := range names {
+		sorted = append(sorted, types.AuctionOutcome(name))
+	}
+	return sorted
+}
+
+func sortedKeys(counts map[string]int) []string {
+	keys := []string{}
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// printZones summarizes how exposed multi-instance apps are to losing a zone
+func printZones(zones []string, repsByZone map[string][]string, zoneInstanceCounts map[string]int, appZoneCounts map[string]map[string]int) {
+	fmt.Println("Zones")
+	for _, zone := range zones {
+		fmt.Printf("  %s: %d reps, %d instances\n", zone, len(repsByZone[zone]), zoneInstanceCounts[zone])
+	}
+
+	numMultiInstanceApps, numSingleZoneApps := 0, 0
+	worstZoneShare := 0.0
+	for _, counts := range appZoneCounts {
+		total, largest := 0, 0
+		for _, count := range counts {
+			total += count
+			if count > largest {
+				largest = count
+			}
+		}
+
+		if total < 2 {
+			continue
+		}
+
+		numMultiInstanceApps++
+		if len(counts) == 1 {
+			numSingleZoneApps++
+		}
+		worstZoneShare += float64(largest) / float64(total)
+	}
+
+	if numMultiInstanceApps == 0 {
+		return
+	}
+
+	color := defaultStyle
+	if numSingleZoneApps > 0 {
+		color = redColor
+	}
+
+	fmt.Printf("  %s%d of %d multi-instance apps live in a single zone%s, losing the worst zone takes out %.1f%% of an app on average\n", color, numSingleZoneApps, numMultiInstanceApps, defaultStyle, 100*worstZoneShare/float64(numMultiInstanceApps))
+}
+
+func overcommitString(total int, capacity int) string {
+	if capacity <= total {
+		return ""
+	}
+	return fmt.Sprintf(" (+%d)", capacity-total)
+}
+
+// headroomTally tracks how much of the remaining room across reps exists only because of overcommit
+type headroomTally struct {
+	free        instance.Resources
+	overcommit  instance.Resources
+	overcommits bool
+}
+
+func (tally *headroomTally) add(total instance.Resources, capacity instance.Resources, used instance.Resources) {
+	free := capacity.Subtract(used)
+	physical := total.Subtract(used)
+
+	tally.free = tally.free.Add(clip(free))
+	tally.overcommit = tally.overcommit.Add(clip(free.Subtract(clip(physical))))
+	if !total.FitsIn(capacity) || !capacity.FitsIn(total) {
+		tally.overcommits = true
+	}
+}
+
+func (tally *headroomTally) print() {
+	if !tally.overcommits {
+		return
+	}
+
+	fmt.Println("Headroom")
+	for _, dimension := range []struct {
+		name             string
+		free, overcommit int
+	}{
+		{"mem", tally.free.MemoryMB, tally.overcommit.MemoryMB},
+		{"disk", tally.free.DiskMB, tally.overcommit.DiskMB},
+		{"containers", tally.free.Containers, tally.overcommit.Containers},
+	} {
+		percentage := 0.0
+		if dimension.free > 0 {
+			percentage = 100 * float64(dimension.overcommit) / float64(dimension.free)
+		}
+		fmt.Printf("  %s: %d free, %d (%.1f%%) from overcommit\n", dimension.name, dimension.free, dimension.overcommit, percentage)
+	}
+}
+
+// qualityTally measures how well instances are spread: how uneven container utilization
+// is across reps, and how many instances sit on a rep beyond their app's even share
+type qualityTally struct {
+	utilizations []float64
+	repAppCounts []map[string]int
+}
+
+func (tally *qualityTally) add(instances []instance.Instance, capacity instance.Resources, used instance.Resources) {
+	if capacity.Containers > 0 {
+		tally.utilizations = append(tally.utilizations, float64(used.Containers)/float64(capacity.Containers))
+	}
+
+	appCounts := map[string]int{}
+	for _, instance := range instances {
+		appCounts[instance.AppGuid]++
+	}
+	tally.repAppCounts = append(tally.repAppCounts, appCounts)
+}
+
+func (tally *qualityTally) print() {
+	if len(tally.utilizations) == 0 {
+		return
+	}
+
+	mean := 0.0
+	minUtilization, maxUtilization := 1e9, 0.0
+	for _, utilization := range tally.utilizations {
+		mean += utilization
+		minUtilization = math.Min(minUtilization, utilization)
+		maxUtilization = math.Max(maxUtilization, utilization)
+	}
+	mean = mean / float64(len(tally.utilizations))
+
+	variance := 0.0
+	for _, utilization := range tally.utilizations {
+		variance += (utilization - mean) * (utilization - mean)
+	}
+	stddev := math.Sqrt(variance / float64(len(tally.utilizations)))
+
+	fmt.Println("Distribution Quality")
+	fmt.Printf("  Container utilization: mean %.1f%%, stddev %.1f%%, min %.1f%%, max %.1f%%\n", 100*mean, 100*stddev, 100*minUtilization, 100*maxUtilization)
+
+	appTotals := map[string]int{}
+	for _, appCounts := range tally.repAppCounts {
+		for appGuid, count := range appCounts {
+			appTotals[appGuid] += count
+		}
+	}
+
+	numInstances, numExcess := 0, 0
+	for _, appCounts := range tally.repAppCounts {
+		for appGuid, count := range appCounts {
+			evenShare := (appTotals[appGuid] + len(tally.repAppCounts) - 1) / len(tally.repAppCounts)
+			numInstances += count
+			numExcess += nonNegative(count - evenShare)
+		}
+	}
+
+	if numInstances > 0 {
+		fmt.Printf("  App spread: %d of %d instances sit beyond an even share of their app on a rep (%.1f%%)\n", numExcess, numInstances, 100*float64(numExcess)/float64(numInstances))
+	}
+}
+
+func clip(r instance.Resources) instance.Resources {
+	return instance.Resources{
+		MemoryMB:   nonNegative(r.MemoryMB),
+		DiskMB:     nonNegative(r.DiskMB),
+		Containers: nonNegative(r.Containers),
+	}
+}
+
+func nonNegative(n int) int {
+	if n < 0 {
+		return 0
+	}
+	return n
+}